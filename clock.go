@@ -0,0 +1,38 @@
+package geoip2
+
+import (
+	"time"
+)
+
+// Clock tells the current time and makes timers for the updater.
+// It can be replaced to test scheduling of updates deterministically.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer made by Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is Clock of the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// realTimer is Timer of the system time.
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
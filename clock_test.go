@@ -0,0 +1,111 @@
+package geoip2
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealClock(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		d time.Duration
+	}{
+		"zero":  {d: 0},
+		"short": {d: 10 * time.Millisecond},
+	}
+
+	for _, t := range tests {
+		clock := realClock{}
+		before := clock.Now()
+		timer := clock.NewTimer(t.d)
+		fired := <-timer.C()
+		assert.False(fired.Before(before.Add(t.d)))
+		assert.False(timer.Stop())
+	}
+}
+
+// testClock is Clock which is advanced manually.
+type testClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*testTimer
+}
+
+func newTestClock(now time.Time) *testClock {
+	return &testClock{now: now}
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *testClock) NewTimer(d time.Duration) Timer {
+	c.Lock()
+	defer c.Unlock()
+	t := &testTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance advances the clock by d and fires expired timers.
+func (c *testClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = timers
+}
+
+// waitTimers waits until n timers are waiting.
+func (c *testClock) waitTimers(n int) bool {
+	for i := 0; i < 500; i++ {
+		c.Lock()
+		waiting := len(c.timers)
+		c.Unlock()
+		if waiting >= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// testTimer is Timer of testClock.
+type testTimer struct {
+	clock *testClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func (t *testTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *testTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
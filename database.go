@@ -0,0 +1,95 @@
+package geoip2
+
+import (
+	"sync/atomic"
+
+	geoip2_golang "github.com/oschwald/geoip2-golang"
+)
+
+// database is a maxmind database shared by lookups without a lock.
+// It is replaced by a new one atomically, and it is closed after lookups using it are finished.
+type database struct {
+	*geoip2_golang.Reader
+	// refs is the number of lookups using database, plus 1 while database is active.
+	refs int64
+	done chan struct{}
+	err  error
+}
+
+// newDatabase returns an active database of reader.
+func newDatabase(reader *geoip2_golang.Reader) *database {
+	return &database{Reader: reader, refs: 1, done: make(chan struct{})}
+}
+
+// acquire marks database in use. It returns false if database is already closed.
+func (d *database) acquire() bool {
+	for {
+		refs := atomic.LoadInt64(&d.refs)
+		if refs <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&d.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release marks database not in use, and closes it by the last user after database is retired.
+func (d *database) release() {
+	if atomic.AddInt64(&d.refs, -1) == 0 {
+		d.err = d.Reader.Close()
+		close(d.done)
+	}
+}
+
+// wait waits until database is closed, and returns an error of closing.
+func (d *database) wait() error {
+	<-d.done
+	return d.err
+}
+
+// activeDB returns the active database, which may be retired at any time unless the lock is held.
+func (r *downloadReader) activeDB() *database {
+	db, _ := r.db.Load().(*database)
+	return db
+}
+
+// acquireDB returns the active database in use, which must be released after a lookup.
+// It returns nil if database isn't loaded.
+func (r *downloadReader) acquireDB() *database {
+	for {
+		db := r.activeDB()
+		if db == nil {
+			return nil
+		}
+		if db.acquire() {
+			return db
+		}
+		// db is retired after it is loaded, so the new one is active.
+	}
+}
+
+// swapDB makes reader active, and returns the old database which must be retired.
+// It must be called with the lock held.
+func (r *downloadReader) swapDB(reader *geoip2_golang.Reader) *database {
+	old := r.activeDB()
+	var db *database
+	if reader != nil {
+		db = newDatabase(reader)
+	}
+	r.db.Store(db)
+	return old
+}
+
+// retireDB closes old database after lookups using it are finished.
+func (r *downloadReader) retireDB(old *database) {
+	if old == nil {
+		return
+	}
+	old.release()
+	go func() {
+		if err := old.wait(); err != nil {
+			r.cfg.logger.Warn("geoip2 old database close failed", "edition", r.cfg.editionId, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package geoip2
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	geoip2_golang "github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabase(t *testing.T) {
+	assert := assert.New(t)
+
+	reader, err := geoip2_golang.FromBytes(testDatabase("GeoLite2-Country", 1, "KR"))
+	assert.NoError(err)
+
+	db := newDatabase(reader)
+	assert.True(db.acquire())
+	assert.True(db.acquire())
+
+	// retired database is closed after lookups are finished.
+	db.release()
+	db.release()
+	select {
+	case <-db.done:
+		assert.Fail("closed before lookups are finished")
+	default:
+	}
+	db.release()
+	assert.NoError(db.wait())
+	assert.False(db.acquire())
+}
+
+func TestDownloadReader_SwapDB(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country"})
+	defer r.cancel()
+	assert.Nil(r.acquireDB())
+
+	tests := []struct {
+		epoch uint32
+		iso   string
+	}{
+		{epoch: 1, iso: "KR"},
+		{epoch: 2, iso: "US"},
+	}
+
+	var inflight *database
+	for _, t := range tests {
+		reader, err := geoip2_golang.FromBytes(testDatabase("GeoLite2-Country", t.epoch, t.iso))
+		assert.NoError(err)
+		r.Lock()
+		r.retireDB(r.swapDB(reader))
+		r.Unlock()
+
+		record, err := r.Country(net.ParseIP("1.1.1.1"))
+		assert.NoError(err)
+		assert.Equal(t.iso, record.Country.IsoCode)
+		if inflight == nil {
+			inflight = r.acquireDB()
+		}
+	}
+
+	// a lookup in progress keeps using the old database.
+	record, err := inflight.Country(net.ParseIP("1.1.1.1"))
+	assert.NoError(err)
+	assert.Equal("KR", record.Country.IsoCode)
+	inflight.release()
+	assert.NoError(inflight.wait())
+}
+
+func TestDownloadReader_CloseWaitsLookups(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-database")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	assert.NoError(ioutil.WriteFile(path, testDatabase("GeoLite2-Country", 1, "KR"), 0644))
+
+	reader, err := OpenWatch(path)
+	assert.NoError(err)
+	r := reader.(*downloadReader)
+	db := r.acquireDB()
+
+	closed := make(chan error)
+	go func() { closed <- r.Close() }()
+	select {
+	case <-closed:
+		assert.Fail("closed before lookups are finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = r.Country(net.ParseIP("1.1.1.1"))
+	assert.True(errors.Is(err, ErrNotReady))
+
+	db.release()
+	assert.NoError(<-closed)
+}
@@ -0,0 +1,103 @@
+package geoip2
+
+import (
+	"errors"
+)
+
+// Stage is a stage of an update where a failure occurred.
+type Stage string
+
+const (
+	// StageChecksum is a stage requesting checksum.
+	StageChecksum Stage = "checksum"
+	// StageDownload is a stage downloading database.
+	StageDownload Stage = "download"
+	// StageVerify is a stage verifying a downloaded database with checksum.
+	StageVerify Stage = "verify"
+	// StageReload is a stage replacing the active database with a downloaded one.
+	StageReload Stage = "reload"
+)
+
+// Event is a lifecycle event of the updater.
+// It is one of CheckStarted, UpToDate, DownloadStarted, DownloadFailed, Reloaded and RolledBack.
+type Event interface {
+	event()
+}
+
+// CheckStarted is emitted when an update starts.
+type CheckStarted struct {
+	Edition string
+}
+
+// UpToDate is emitted when the active database is the latest.
+type UpToDate struct {
+	Edition  string
+	Checksum string
+}
+
+// DownloadStarted is emitted before database is downloaded.
+type DownloadStarted struct {
+	Edition string
+	Attempt int
+}
+
+// DownloadFailed is emitted when a stage of an update failed.
+// HTTPStatus is zero if the failure isn't caused by an unexpected HTTP status.
+type DownloadFailed struct {
+	Edition    string
+	Stage      Stage
+	HTTPStatus int
+	Attempt    int
+	Err        error
+}
+
+// Reloaded is emitted when a downloaded database replaced the active database.
+type Reloaded struct {
+	Edition  string
+	OldEpoch uint
+	NewEpoch uint
+	Checksum string
+}
+
+// RolledBack is emitted when a new database couldn't be loaded and the old database is restored.
+type RolledBack struct {
+	Edition string
+	Err     error
+}
+
+func (CheckStarted) event()    {}
+func (UpToDate) event()        {}
+func (DownloadStarted) event() {}
+func (DownloadFailed) event()  {}
+func (Reloaded) event()        {}
+func (RolledBack) event()      {}
+
+// emit logs ev and calls event functions with it.
+// successFunc and errorFunc are called as adapters of Reloaded and DownloadFailed.
+func (r *downloadReader) emit(ev Event) {
+	logEvent(r.cfg.logger, ev)
+	switch e := ev.(type) {
+	case Reloaded:
+		r.cfg.successFunc()
+	case DownloadFailed:
+		r.cfg.errorFunc(e.Err)
+	}
+	for _, f := range r.cfg.eventFuncs {
+		f(ev)
+	}
+}
+
+// downloadFailed emits DownloadFailed of err.
+// A checksum mismatch is reported as StageVerify whatever stage is.
+func (r *downloadReader) downloadFailed(stage Stage, attempt int, err error) {
+	var mismatch *ChecksumMismatchError
+	if errors.As(err, &mismatch) {
+		stage = StageVerify
+	}
+	var derr *DownloadError
+	code := 0
+	if errors.As(err, &derr) {
+		code = derr.StatusCode
+	}
+	r.emit(DownloadFailed{Edition: r.cfg.editionId, Stage: stage, HTTPStatus: code, Attempt: attempt, Err: err})
+}
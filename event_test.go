@@ -0,0 +1,136 @@
+package geoip2
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadReader_Events(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	fail := false
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 7, "KR")})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		testHandler(archive).ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-events")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	var events []Event
+	successes, errs := 0, 0
+	reader, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)),
+		WithEventFunc(func(ev Event) {
+			// errors are compared separately.
+			switch e := ev.(type) {
+			case DownloadFailed:
+				assert.Error(e.Err)
+				e.Err = nil
+				ev = e
+			case RolledBack:
+				assert.Error(e.Err)
+				e.Err = nil
+				ev = e
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+		}),
+		WithSuccessFunc(func() { successes++ }),
+		WithErrorFunc(func(error) { errs++ }))
+	assert.NoError(err)
+	defer reader.Close()
+	reader.Pause()
+
+	edition := "GeoLite2-Country"
+	checksum := fmt.Sprintf("%x", md5.Sum(archive))
+	newArchive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 8, "KR")})
+	newChecksum := fmt.Sprintf("%x", md5.Sum(newArchive))
+
+	tests := []struct {
+		name    string
+		fail    bool
+		archive []byte
+		events  []Event
+	}{
+		{
+			name:    "up-to-date",
+			archive: archive,
+			events:  []Event{CheckStarted{Edition: edition}, UpToDate{Edition: edition, Checksum: checksum}},
+		},
+		{
+			name:    "checksum-fail",
+			fail:    true,
+			archive: archive,
+			events: []Event{
+				CheckStarted{Edition: edition},
+				DownloadFailed{Edition: edition, Stage: StageChecksum, HTTPStatus: http.StatusInternalServerError, Attempt: 1},
+			},
+		},
+		{
+			name:    "rollback",
+			archive: testArchive(map[string][]byte{"GeoLite2-Country.mmdb": []byte("broken")}),
+			events: []Event{
+				CheckStarted{Edition: edition},
+				DownloadStarted{Edition: edition, Attempt: 1},
+				RolledBack{Edition: edition},
+				DownloadFailed{Edition: edition, Stage: StageReload, Attempt: 1},
+			},
+		},
+		{
+			name:    "reloaded",
+			archive: newArchive,
+			events: []Event{
+				CheckStarted{Edition: edition},
+				DownloadStarted{Edition: edition, Attempt: 1},
+				Reloaded{Edition: edition, OldEpoch: 7, NewEpoch: 8, Checksum: newChecksum},
+			},
+		},
+	}
+
+	// the first download is finished before an update.
+	_, err = reader.Update(context.Background())
+	assert.NoError(err)
+	mu.Lock()
+	assert.Equal([]Event{
+		CheckStarted{Edition: edition},
+		DownloadStarted{Edition: edition, Attempt: 1},
+		Reloaded{Edition: edition, OldEpoch: 0, NewEpoch: 7, Checksum: checksum},
+	}, events[:3])
+	events = nil
+	mu.Unlock()
+
+	for _, t := range tests {
+		mu.Lock()
+		fail = t.fail
+		archive = t.archive
+		events = events[:0]
+		mu.Unlock()
+
+		reader.Update(context.Background())
+
+		mu.Lock()
+		assert.Equal(t.events, events, t.name)
+		mu.Unlock()
+	}
+	assert.Equal(2, successes)
+	assert.Equal(2, errs)
+}
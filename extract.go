@@ -0,0 +1,272 @@
+package geoip2
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultMaxDatabaseSize is the default maximum decompressed size of a downloaded database.
+	DefaultMaxDatabaseSize = int64(2 << 30)
+)
+
+var (
+	ErrArchiveTooLarge         = fmt.Errorf("[err] archive too large")
+	ErrArchiveNotFoundDatabase = fmt.Errorf("[err] not found database in archive")
+	ErrArchiveAmbiguous        = fmt.Errorf("[err] archive has several databases")
+	ErrArchiveSuspiciousPath   = fmt.Errorf("[err] archive has suspicious path")
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+	// metadataMarker starts metadata section in the last 128KiB of a database.
+	metadataMarker    = []byte("\xab\xcd\xefMaxMind.com")
+	metadataMaxLength = 128 * 1024
+)
+
+// limitedReader reads from r up to n bytes, and returns ErrArchiveTooLarge if r has more bytes.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// check whether r has more bytes.
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, ErrArchiveTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// extractDatabase copies <editionId>.mmdb(or any single .mmdb if editionId is empty) in artifact r to w.
+// The artifact format(tar.gz, mmdb.gz, zip or plain mmdb) is detected from magic bytes,
+// and suffix is used to tell tar.gz from mmdb.gz. The decompressed artifact must not be larger than maxSize,
+// and an archive must have exactly one database without suspicious paths.
+func extractDatabase(w io.Writer, r io.Reader, suffix MaxmindDownloadSuffix, editionId string, maxSize int64) error {
+	if w == nil || r == nil || maxSize <= 0 {
+		return fmt.Errorf("[err] extractDatabase %w", ErrInvalidParameters)
+	}
+
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zipMagic))
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return extractZip(w, br, editionId, maxSize)
+	case bytes.HasPrefix(magic, gzipMagic):
+		// wrapping unzip reader
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("[err] extractDatabase %w", err)
+		}
+		defer gr.Close()
+		lr := &limitedReader{r: gr, n: maxSize}
+
+		switch suffix {
+		case GZIP:
+			return extractTar(w, lr, editionId, maxSize)
+		case MMDBGZIP:
+			return extractMMDB(w, lr)
+		}
+
+		// sniff tar header in the decompressed stream.
+		bgr := bufio.NewReader(lr)
+		if header, _ := bgr.Peek(257 + len(tarMagic)); len(header) > 257 && bytes.Equal(header[257:], tarMagic) {
+			return extractTar(w, bgr, editionId, maxSize)
+		}
+		return extractMMDB(w, bgr)
+	default:
+		return extractMMDB(w, &limitedReader{r: br, n: maxSize})
+	}
+}
+
+// extractTar copies <editionId>.mmdb in tar stream r to w.
+func extractTar(w io.Writer, r io.Reader, editionId string, maxSize int64) error {
+	tr := tar.NewReader(r)
+
+	found := false
+	for {
+		header, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			if !found {
+				return fmt.Errorf("[err] extractTar %w", ErrArchiveNotFoundDatabase)
+			}
+			return nil
+		case err != nil:
+			return fmt.Errorf("[err] extractTar %w", err)
+		}
+
+		if !safeArchivePath(header.Name) {
+			return fmt.Errorf("[err] extractTar %s %w", header.Name, ErrArchiveSuspiciousPath)
+		}
+		if !databaseArchivePath(header.Name, editionId) {
+			continue
+		}
+
+		// a database must be a regular file.
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("[err] extractTar %s %w", header.Name, ErrArchiveSuspiciousPath)
+		}
+		if found {
+			return fmt.Errorf("[err] extractTar %s %w", header.Name, ErrArchiveAmbiguous)
+		}
+		if header.Size > maxSize {
+			return fmt.Errorf("[err] extractTar %s %w", header.Name, ErrArchiveTooLarge)
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return fmt.Errorf("[err] extractTar %w", err)
+		}
+		found = true
+	}
+}
+
+// extractZip copies <editionId>.mmdb in zip archive r to w.
+// Because zip needs random access, r is spooled to a temporary file next to w.
+// The spool is named after w, so that it is cleaned up with the staged download if the process crashes.
+func extractZip(w io.Writer, r io.Reader, editionId string, maxSize int64) error {
+	dir, pattern := os.TempDir(), "geoip2-*.zip"
+	if f, ok := w.(*os.File); ok {
+		dir, pattern = filepath.Dir(f.Name()), filepath.Base(f.Name())+"-*.zip"
+	}
+	spool, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return fmt.Errorf("[err] extractZip %w", err)
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	size, err := io.Copy(spool, &limitedReader{r: r, n: maxSize})
+	if err != nil {
+		return fmt.Errorf("[err] extractZip %w", err)
+	}
+	zr, err := zip.NewReader(spool, size)
+	if err != nil {
+		return fmt.Errorf("[err] extractZip %w", err)
+	}
+
+	var database *zip.File
+	for _, file := range zr.File {
+		if !safeArchivePath(file.Name) {
+			return fmt.Errorf("[err] extractZip %s %w", file.Name, ErrArchiveSuspiciousPath)
+		}
+		if !databaseArchivePath(file.Name, editionId) {
+			continue
+		}
+
+		// a database must be a regular file.
+		if !file.Mode().IsRegular() {
+			return fmt.Errorf("[err] extractZip %s %w", file.Name, ErrArchiveSuspiciousPath)
+		}
+		if database != nil {
+			return fmt.Errorf("[err] extractZip %s %w", file.Name, ErrArchiveAmbiguous)
+		}
+		if file.UncompressedSize64 > uint64(maxSize) {
+			return fmt.Errorf("[err] extractZip %s %w", file.Name, ErrArchiveTooLarge)
+		}
+		database = file
+	}
+	if database == nil {
+		return fmt.Errorf("[err] extractZip %w", ErrArchiveNotFoundDatabase)
+	}
+
+	fr, err := database.Open()
+	if err != nil {
+		return fmt.Errorf("[err] extractZip %w", err)
+	}
+	defer fr.Close()
+	if _, err := io.Copy(w, &limitedReader{r: fr, n: maxSize}); err != nil {
+		return fmt.Errorf("[err] extractZip %w", err)
+	}
+	return nil
+}
+
+// extractMMDB copies plain database r to w.
+func extractMMDB(w io.Writer, r io.Reader) error {
+	tail := &tailWriter{size: metadataMaxLength}
+	if _, err := io.Copy(io.MultiWriter(w, tail), r); err != nil {
+		return fmt.Errorf("[err] extractMMDB %w", err)
+	}
+	// a plain artifact has no structure to check, so at least it must have database metadata.
+	if !bytes.Contains(tail.buf, metadataMarker) {
+		return fmt.Errorf("[err] extractMMDB %w", ErrArchiveNotFoundDatabase)
+	}
+	return nil
+}
+
+// tailWriter keeps the last size bytes written.
+type tailWriter struct {
+	buf  []byte
+	size int
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.size {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.size:]...)
+	}
+	return len(p), nil
+}
+
+// safeArchivePath returns whether name is a relative path which stays in an archive.
+func safeArchivePath(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") || path.IsAbs(name) {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// databaseArchivePath returns whether name is a database path of editionId.
+// If editionId is empty, any database path matches.
+func databaseArchivePath(name, editionId string) bool {
+	base := path.Base(path.Clean(name))
+	if editionId == "" {
+		return strings.HasSuffix(base, ".mmdb")
+	}
+	return base == editionId+".mmdb"
+}
+
+// archiveFile returns whether file is an archive(gzip or zip) by its magic bytes.
+func archiveFile(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	magic = magic[:n]
+	return bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zipMagic), nil
+}
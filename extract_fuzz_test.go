@@ -0,0 +1,30 @@
+//go:build go1.18
+// +build go1.18
+
+package geoip2
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+func FuzzExtractDatabase(f *testing.F) {
+	db := testDatabase("GeoLite2-Country", 1, "KR")
+	f.Add(testArchive(map[string][]byte{"GeoLite2-Country_20200101/GeoLite2-Country.mmdb": db}))
+	f.Add(testArchive(map[string][]byte{"a/GeoLite2-Country.mmdb": db, "b/GeoLite2-Country.mmdb": db}))
+	f.Add(testArchive(map[string][]byte{"../GeoLite2-Country.mmdb": db}))
+	f.Add(testArchiveEntries(testArchiveEntry{header: &tar.Header{Name: "GeoLite2-Country.mmdb",
+		Linkname: "GeoLite2-City.mmdb", Typeflag: tar.TypeLink}}))
+	f.Add(db)
+	f.Add([]byte{})
+
+	const maxSize = 1 << 16
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		buf := &bytes.Buffer{}
+		err := extractDatabase(buf, bytes.NewReader(archive), "", "GeoLite2-Country", maxSize)
+		if buf.Len() > maxSize {
+			t.Fatalf("extracted %d bytes over max size %d (err %v)", buf.Len(), maxSize, err)
+		}
+	})
+}
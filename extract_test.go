@@ -0,0 +1,173 @@
+package geoip2
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractDatabase(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDatabase("GeoLite2-Country", 1, "KR")
+	reg := func(name string, body []byte) testArchiveEntry {
+		return testArchiveEntry{header: &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)),
+			Typeflag: tar.TypeReg}, body: body}
+	}
+
+	gz := func(body []byte) []byte {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		gw.Write(body)
+		gw.Close()
+		return buf.Bytes()
+	}
+	zipped := func(files ...string) []byte {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		for _, name := range files {
+			fw, _ := zw.Create(name)
+			fw.Write(db)
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := map[string]struct {
+		archive []byte
+		suffix  MaxmindDownloadSuffix
+		maxSize int64
+		output  []byte
+		err     error
+	}{
+		"success": {archive: testArchiveEntries(
+			testArchiveEntry{header: &tar.Header{Name: "GeoLite2-Country_20200101/", Mode: 0755, Typeflag: tar.TypeDir}},
+			reg("GeoLite2-Country_20200101/COPYRIGHT.txt", []byte("copyright")),
+			reg("GeoLite2-Country_20200101/GeoLite2-Country.mmdb", db),
+			reg("GeoLite2-Country_20200101/LICENSE.txt", []byte("license")),
+		), maxSize: DefaultMaxDatabaseSize, output: db},
+		"tar.gz suffix": {archive: testArchiveEntries(reg("GeoLite2-Country.mmdb", db)), suffix: GZIP,
+			maxSize: DefaultMaxDatabaseSize, output: db},
+		"mmdb":           {archive: db, suffix: MMDB, maxSize: DefaultMaxDatabaseSize, output: db},
+		"mmdb detected":  {archive: db, maxSize: DefaultMaxDatabaseSize, output: db},
+		"mmdb.gz":        {archive: gz(db), suffix: MMDBGZIP, maxSize: DefaultMaxDatabaseSize, output: db},
+		"mmdb.gz detect": {archive: gz(db), maxSize: DefaultMaxDatabaseSize, output: db},
+		"mmdb too large": {archive: gz(db), suffix: MMDBGZIP, maxSize: int64(len(db)) - 1, err: ErrArchiveTooLarge},
+		"zip": {archive: zipped("GeoLite2-Country_20200101/GeoLite2-Country.mmdb", "LICENSE.txt"), suffix: ZIP,
+			maxSize: DefaultMaxDatabaseSize, output: db},
+		"zip ambiguous": {archive: zipped("a/GeoLite2-Country.mmdb", "b/GeoLite2-Country.mmdb"),
+			maxSize: DefaultMaxDatabaseSize, err: ErrArchiveAmbiguous},
+		"zip parent path": {archive: zipped("../GeoLite2-Country.mmdb"), maxSize: DefaultMaxDatabaseSize,
+			err: ErrArchiveSuspiciousPath},
+		"zip other edition": {archive: zipped("GeoLite2-City.mmdb"), maxSize: DefaultMaxDatabaseSize,
+			err: ErrArchiveNotFoundDatabase},
+		"not database": {archive: []byte("<html>not found</html>"), suffix: MMDB, maxSize: DefaultMaxDatabaseSize,
+			err: ErrArchiveNotFoundDatabase},
+		"other edition": {archive: testArchiveEntries(reg("GeoLite2-City.mmdb", db)),
+			maxSize: DefaultMaxDatabaseSize, err: ErrArchiveNotFoundDatabase},
+		"ambiguous": {archive: testArchiveEntries(reg("a/GeoLite2-Country.mmdb", db), reg("b/GeoLite2-Country.mmdb", db)),
+			maxSize: DefaultMaxDatabaseSize, err: ErrArchiveAmbiguous},
+		"parent path": {archive: testArchiveEntries(reg("../GeoLite2-Country.mmdb", db)),
+			maxSize: DefaultMaxDatabaseSize, err: ErrArchiveSuspiciousPath},
+		"absolute path": {archive: testArchiveEntries(reg("/etc/GeoLite2-Country.mmdb", db)),
+			maxSize: DefaultMaxDatabaseSize, err: ErrArchiveSuspiciousPath},
+		"symlink": {archive: testArchiveEntries(testArchiveEntry{header: &tar.Header{Name: "GeoLite2-Country.mmdb",
+			Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}}),
+			maxSize: DefaultMaxDatabaseSize, err: ErrArchiveSuspiciousPath},
+		"too large database": {archive: testArchiveEntries(reg("GeoLite2-Country.mmdb", db)),
+			maxSize: int64(len(db)) - 1, err: ErrArchiveTooLarge},
+		"too large archive": {archive: testArchiveEntries(reg("GeoLite2-Country.mmdb", db),
+			reg("LICENSE.txt", make([]byte, 1<<20))), maxSize: 1 << 19, err: ErrArchiveTooLarge},
+		"invalid": {archive: gz([]byte("not archive")), suffix: GZIP, maxSize: DefaultMaxDatabaseSize,
+			err: errors.New("")},
+	}
+
+	for k, t := range tests {
+		buf := &bytes.Buffer{}
+		err := extractDatabase(buf, bytes.NewReader(t.archive), t.suffix, "GeoLite2-Country", t.maxSize)
+		switch {
+		case t.err == nil:
+			assert.NoError(err, k)
+			assert.Equal(t.output, buf.Bytes(), k)
+		case t.err.Error() == "":
+			assert.Error(err, k)
+		default:
+			assert.True(errors.Is(err, t.err), k)
+		}
+	}
+}
+
+func TestSafeArchivePath(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		name   string
+		output bool
+	}{
+		"file":      {name: "GeoLite2-Country.mmdb", output: true},
+		"directory": {name: "GeoLite2-Country_20200101/GeoLite2-Country.mmdb", output: true},
+		"dot":       {name: "./GeoLite2-Country.mmdb", output: true},
+		"empty":     {name: "", output: false},
+		"parent":    {name: "a/../../GeoLite2-Country.mmdb", output: false},
+		"absolute":  {name: "/GeoLite2-Country.mmdb", output: false},
+		"backslash": {name: "..\\GeoLite2-Country.mmdb", output: false},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, safeArchivePath(t.name))
+	}
+}
+
+// testSpyReader calls spy when r is read to the end.
+type testSpyReader struct {
+	r   io.Reader
+	spy func()
+}
+
+func (r *testSpyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.spy()
+	}
+	return n, err
+}
+
+func TestExtractZip_Spool(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-spool")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	fw, _ := zw.Create("GeoLite2-Country.mmdb")
+	fw.Write(testDatabase("GeoLite2-Country", 1, "KR"))
+	zw.Close()
+
+	cfg := &downloadConfig{editionId: "GeoLite2-Country", storeDir: dir}
+	w, err := ioutil.TempFile(dir, cfg.stagingPattern())
+	assert.NoError(err)
+	defer w.Close()
+
+	// the spool matches staged downloads which are cleaned up after a crash.
+	var spooled []string
+	r := &testSpyReader{r: buf, spy: func() {
+		spooled, _ = filepath.Glob(filepath.Join(dir, cfg.stagingPattern()+".zip"))
+	}}
+	assert.NoError(extractZip(w, r, "GeoLite2-Country", DefaultMaxDatabaseSize))
+	assert.Len(spooled, 1)
+
+	// the spool is removed after extraction.
+	files, _ := filepath.Glob(filepath.Join(dir, cfg.stagingPattern()))
+	assert.Equal([]string{w.Name()}, files)
+}
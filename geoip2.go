@@ -1,11 +1,19 @@
 package geoip2
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"github.com/cenkalti/backoff/v4"
+	"io"
+	"io/fs"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	geoip2_golang "github.com/oschwald/geoip2-golang"
 	maxminddb "github.com/oschwald/maxminddb-golang"
 )
@@ -14,16 +22,70 @@ type MaxmindDownloadSuffix string
 
 const (
 	MaxmindDownloadFormat = "https://download.maxmind.com/app/geoip_download?license_key=%s&edition_id=%s&suffix=%s"
-	GZIP                  = MaxmindDownloadSuffix("tar.gz")
-	MD5                   = MaxmindDownloadSuffix("tar.gz.md5")
+	// MaxmindDatabaseDownloadFormat is the download API authenticated with account id and license key.
+	MaxmindDatabaseDownloadFormat = "https://download.maxmind.com/geoip/databases/%[2]s/download?suffix=%[3]s"
+	DefaultUserAgent              = "go-geoip2"
+	GZIP                          = MaxmindDownloadSuffix("tar.gz")
+	MMDB                          = MaxmindDownloadSuffix("mmdb")
+	MMDBGZIP                      = MaxmindDownloadSuffix("mmdb.gz")
+	ZIP                           = MaxmindDownloadSuffix("zip")
+	MD5                           = MaxmindDownloadSuffix("tar.gz.md5")
+	SHA256                        = MaxmindDownloadSuffix("tar.gz.sha256")
+	redactedValue                 = "REDACTED"
 )
 
 var (
 	ErrInvalidParameters = fmt.Errorf("[err] invalid parameters")
 	ErrNotFoundDatabase  = fmt.Errorf("[err] not found database")
 	ErrFirstDownloadFail = fmt.Errorf("[err] first download fail")
+	ErrNotReady          = fmt.Errorf("[err] database not ready")
+	ErrThrottled         = fmt.Errorf("[err] requests are suspended")
+	ErrQuotaExceeded     = fmt.Errorf("[err] daily request budget exceeded")
 )
 
+// ChecksumMismatchError is returned when a downloaded archive doesn't match its checksum.
+type ChecksumMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("[err] checksum mismatch expected %s actual %s", e.Expected, e.Actual)
+}
+
+// DownloadError is returned when checksum or database of an edition couldn't be downloaded.
+// Op is StageChecksum or StageDownload, and StatusCode is zero if a response isn't received.
+type DownloadError struct {
+	Op         Stage
+	Edition    string
+	StatusCode int
+	Attempt    int
+	Err        error
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("[err] %s %s attempt %d %v", e.Op, e.Edition, e.Attempt, e.Err)
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// ReloadError is returned when a database at Path couldn't replace the active database.
+type ReloadError struct {
+	Edition string
+	Path    string
+	Err     error
+}
+
+func (e *ReloadError) Error() string {
+	return fmt.Sprintf("[err] reload %s %v", e.Edition, e.Err)
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
+
 // support to interface for oschwald/geoip2-golang.
 type Reader interface {
 	ASN(ipAddress net.IP) (*geoip2_golang.ASN, error)
@@ -38,8 +100,52 @@ type Reader interface {
 	Close() error
 }
 
+// Source is where the active database of UpdateReader comes from.
+type Source string
+
+const (
+	// SourceNone means no database is loaded.
+	SourceNone = Source("")
+	// SourceStore means the database stored in storeDir by a previous run.
+	SourceStore = Source("store")
+	// SourceSeed means the seed database which is used until the first download succeeds.
+	SourceSeed = Source("seed")
+	// SourceDownload means the database downloaded by the updater.
+	SourceDownload = Source("download")
+	// SourceFile means the database of a file watched by OpenWatch.
+	SourceFile = Source("file")
+)
+
+// UpdateReader is geoip Reader which updates automatically the latest maxmind databases.
+type UpdateReader interface {
+	Reader
+	// Source returns where the active database comes from.
+	Source() Source
+	// Ready returns a channel which is closed when a database is loaded first.
+	Ready() <-chan struct{}
+	// WaitReady waits until a database is loaded first.
+	WaitReady(ctx context.Context) error
+	// Update checks and downloads the latest database immediately.
+	Update(ctx context.Context) (UpdateResult, error)
+	// Pause pauses background updates.
+	Pause()
+	// Resume resumes background updates paused by Pause.
+	Resume()
+	// Status returns a status of the updater.
+	Status() Status
+}
+
 // Open returns geoip Reader from a local file.
+// If the file is an archive(tar.gz, mmdb.gz or zip), it is opened with OpenArchive.
 func Open(file string) (Reader, error) {
+	archive, err := archiveFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if archive {
+		return OpenArchive(file)
+	}
+
 	db, err := geoip2_golang.Open(file)
 	if err != nil {
 		return nil, err
@@ -48,74 +154,207 @@ func Open(file string) (Reader, error) {
 	return &fileReader{db}, nil
 }
 
-// OpenURL returns geoip Reader from maxmind download URL and updates automatically the latest maxmind databases.
-// reference: maxmind URL https://dev.maxmind.com/geoip/geoipupdate/#Direct_Downloads
-func OpenURL(licenseKey, editionId, storeDir string, opts ...DownloadOption) (Reader, error) {
-	if licenseKey == "" || editionId == "" || storeDir == "" {
-		return nil, fmt.Errorf("[err] OpenURL %w", ErrInvalidParameters)
+// OpenArchive returns geoip Reader from a local archive(tar.gz, mmdb.gz or zip) which has a single database.
+// The database is extracted into memory.
+func OpenArchive(file string) (Reader, error) {
+	if file == "" {
+		return nil, fmt.Errorf("[err] OpenArchive %w", ErrInvalidParameters)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("[err] OpenArchive %w", err)
+	}
+	defer f.Close()
+
+	reader, err := OpenReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("[err] OpenArchive %w", err)
+	}
+	return reader, nil
+}
+
+// FromBytes returns geoip Reader from database bytes.
+// The bytes are used directly, so they must not be modified after.
+func FromBytes(data []byte) (Reader, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("[err] FromBytes %w", ErrInvalidParameters)
 	}
 
-	// generate maxmind download URL
-	downloadURL, err := MaxmindDownloadURL(licenseKey, editionId, GZIP)
+	db, err := geoip2_golang.FromBytes(data)
 	if err != nil {
-		return nil, fmt.Errorf("[err] OpenURL %w", err)
+		return nil, fmt.Errorf("[err] FromBytes %w", err)
+	}
+
+	return &fileReader{db}, nil
+}
+
+// OpenReader returns geoip Reader from r which is a database or an archive(tar.gz, mmdb.gz or zip).
+// The database is read into memory.
+func OpenReader(r io.Reader) (Reader, error) {
+	data, err := readDatabase(r)
+	if err != nil {
+		return nil, fmt.Errorf("[err] OpenReader %w", err)
+	}
+	return FromBytes(data)
+}
+
+// OpenFS returns geoip Reader from name in fsys(e.g. embed.FS) which is a database or an archive.
+func OpenFS(fsys fs.FS, name string) (Reader, error) {
+	data, err := readDatabaseFS(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("[err] OpenFS %w", err)
+	}
+	return FromBytes(data)
+}
+
+// readDatabase reads a database from r which is a database or an archive.
+func readDatabase(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, fmt.Errorf("[err] readDatabase %w", ErrInvalidParameters)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := extractDatabase(buf, r, "", "", DefaultMaxDatabaseSize); err != nil {
+		return nil, fmt.Errorf("[err] readDatabase %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	// generate maxmind checksum URL
-	checkSumURL, err := MaxmindDownloadURL(licenseKey, editionId, MD5)
+// readDatabaseFS reads a database from name in fsys which is a database or an archive.
+func readDatabaseFS(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil || name == "" {
+		return nil, fmt.Errorf("[err] readDatabaseFS %w", ErrInvalidParameters)
+	}
+
+	f, err := fsys.Open(name)
 	if err != nil {
-		return nil, fmt.Errorf("[err] OpenURL %w", err)
+		return nil, fmt.Errorf("[err] readDatabaseFS %w", err)
+	}
+	defer f.Close()
+
+	return readDatabase(f)
+}
+
+// OpenURL returns geoip Reader from maxmind download URL and updates automatically the latest maxmind databases.
+// Processes sharing storeDir take a file lock, so that only one of them downloads databases and the others reload them.
+// The lock is supported on unix and windows. On other platforms every process downloads databases, so storeDir must not be shared.
+// reference: maxmind URL https://dev.maxmind.com/geoip/geoipupdate/#Direct_Downloads
+func OpenURL(licenseKey, editionId, storeDir string, opts ...DownloadOption) (UpdateReader, error) {
+	return OpenURLContext(context.Background(), licenseKey, editionId, storeDir, opts...)
+}
+
+// OpenURLContext is the same as OpenURL, but the background updater stops when ctx is canceled.
+// HTTP requests and backoff sleeps of the updater are canceled with ctx as well.
+func OpenURLContext(ctx context.Context, licenseKey, editionId, storeDir string, opts ...DownloadOption) (UpdateReader, error) {
+	if ctx == nil || licenseKey == "" || editionId == "" || storeDir == "" {
+		return nil, fmt.Errorf("[err] OpenURLContext %w", ErrInvalidParameters)
 	}
 
 	cfg := &downloadConfig{
 		licenseKey:        licenseKey,
 		editionId:         editionId,
-		downloadURL:       downloadURL,
-		checksumURL:       checkSumURL,
+		downloadFormat:    MaxmindDownloadFormat,
+		downloadSuffix:    GZIP,
+		maxDatabaseSize:   DefaultMaxDatabaseSize,
 		storeDir:          storeDir,
 		firstDownloadWait: 10 * time.Second,
 		updateInterval:    time.Hour,
 		retries:           1,
 		successFunc:       func() {},
 		errorFunc:         func(err error) {},
+		logger:            nopLogger{},
+		clock:             realClock{},
+		httpClient:        http.DefaultClient,
+		requestTimeout:    10 * time.Minute,
+		userAgent:         DefaultUserAgent,
 	}
 
 	// dependency injection.
 	for _, opt := range opts {
 		opt.apply(cfg)
 	}
+	if cfg.backoff == nil {
+		cfg.backoff = backoff.NewExponentialBackOff()
+	}
+
+	// account id uses the download API authenticated with basic auth, which serves only SHA256 checksums.
+	if cfg.accountId != "" && cfg.downloadFormat == MaxmindDownloadFormat {
+		cfg.downloadFormat = MaxmindDatabaseDownloadFormat
+	}
+	if cfg.checksumSuffix == "" {
+		cfg.checksumSuffix = MD5
+		if cfg.accountId != "" {
+			cfg.checksumSuffix = SHA256
+		}
+	}
+
+	switch cfg.downloadSuffix {
+	case GZIP, MMDB, MMDBGZIP, ZIP:
+	default:
+		return nil, fmt.Errorf("[err] OpenURLContext %w", ErrInvalidParameters)
+	}
+	if cfg.maxDatabaseSize <= 0 || (cfg.checksumSuffix != MD5 && cfg.checksumSuffix != SHA256) {
+		return nil, fmt.Errorf("[err] OpenURLContext %w", ErrInvalidParameters)
+	}
+
+	// generate download and checksum URLs for the endpoint and mirrors.
+	for _, format := range append([]string{cfg.downloadFormat}, cfg.mirrors...) {
+		dbURL, err := downloadURL(format, licenseKey, editionId, cfg.downloadSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("[err] OpenURLContext %w", err)
+		}
+		checksumURL, err := downloadURL(format, licenseKey, editionId, cfg.checksumURLSuffix())
+		if err != nil {
+			return nil, fmt.Errorf("[err] OpenURLContext %w", err)
+		}
+		cfg.downloadURLs = append(cfg.downloadURLs, dbURL)
+		cfg.checksumURLs = append(cfg.checksumURLs, checksumURL)
+	}
 
-	reader := &downloadReader{
-		runDownloadClose: make(chan bool),
-		cfg: cfg, backoff: backoff.NewExponentialBackOff()}
+	reader := newDownloadReader(ctx, cfg)
 
 	// if maxmind database is already exist, using it.
-	reader.databaseReload(reader.cfg.dbPath(), "")
+	// the updater of the store cleans up staged downloads of crashed runs, and other processes only read the store.
+	if reader.lockStore() {
+		reader.databaseReload(reader.cfg.dbPath(), "", validators{})
+	} else {
+		reader.followUpdate()
+	}
+
+	// if not, using seed database until the first download succeeds.
+	if !reader.loaded() && reader.cfg.seed != nil {
+		if err := reader.seedLoad(); err != nil {
+			// the updater is not started yet.
+			reader.cancel()
+			reader.unlockStore()
+			return nil, fmt.Errorf("[err] OpenURLContext %w", err)
+		}
+	}
 
 	// run update and download logic async
 	go reader.runDownloadURL()
 
-	// if default db exists, returning.
-	if reader.db != nil {
+	// if default db exists or non-blocking, returning.
+	if reader.loaded() || reader.cfg.nonBlocking {
 		return reader, nil
 	}
 
 	// wait first download success
-Wait:
-	for {
-		select {
-		case <-time.After(time.Second):
-			reader.RLock()
-			if reader.db != nil {
-				reader.RUnlock()
-				break Wait
-			}
-			reader.RUnlock()
-		case <-time.After(reader.cfg.firstDownloadWait):
-			break Wait
-		}
+	timeout := time.NewTimer(reader.cfg.firstDownloadWait)
+	defer timeout.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timeout.C:
+	case <-reader.Ready():
 	}
-	if reader.db == nil {
+
+	if !reader.loaded() {
+		// stop the updater and release everything started above.
+		reader.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("[err] OpenURLContext %w", ctx.Err())
+		}
 		return nil, ErrFirstDownloadFail
 	}
 
@@ -125,8 +364,57 @@ Wait:
 // maxmindDownloadURL returns Maxmind download URL
 // reference: maxmind URL https://dev.maxmind.com/geoip/geoipupdate/#Direct_Downloads
 func MaxmindDownloadURL(licenseKey, editionId string, suffix MaxmindDownloadSuffix) (string, error) {
-	if licenseKey == "" || editionId == "" || suffix == "" {
-		return "", fmt.Errorf("[err] maxmindDownloadURL %w", ErrInvalidParameters)
+	url, err := downloadURL(MaxmindDownloadFormat, licenseKey, editionId, suffix)
+	if err != nil {
+		return "", fmt.Errorf("[err] maxmindDownloadURL %w", err)
+	}
+	return url, nil
+}
+
+// MaxmindDatabaseDownloadURL returns Maxmind download URL authenticated with account id and license key.
+// reference: maxmind URL https://dev.maxmind.com/geoip/updating-databases#directly-downloading-databases
+func MaxmindDatabaseDownloadURL(editionId string, suffix MaxmindDownloadSuffix) (string, error) {
+	if editionId == "" || suffix == "" {
+		return "", fmt.Errorf("[err] MaxmindDatabaseDownloadURL %w", ErrInvalidParameters)
+	}
+	return fmt.Sprintf(MaxmindDatabaseDownloadFormat, "", editionId, suffix), nil
+}
+
+// Redacted returns rawURL whose license key and password are replaced, so that it is safe to print.
+func Redacted(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		}
+	}
+	// keep the order of query parameters.
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		key := strings.SplitN(param, "=", 2)[0]
+		if key, err := url.QueryUnescape(key); err == nil && key == "license_key" {
+			params[i] = key + "=" + redactedValue
+		}
+	}
+	u.RawQuery = strings.Join(params, "&")
+	return u.String()
+}
+
+// redact returns s whose licenseKey is replaced.
+func redact(s, licenseKey string) string {
+	if licenseKey == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, licenseKey, redactedValue)
+}
+
+// downloadURL returns download URL formatted by format.
+func downloadURL(format, licenseKey, editionId string, suffix MaxmindDownloadSuffix) (string, error) {
+	if format == "" || licenseKey == "" || editionId == "" || suffix == "" {
+		return "", fmt.Errorf("[err] downloadURL %w", ErrInvalidParameters)
 	}
-	return fmt.Sprintf(MaxmindDownloadFormat, licenseKey, editionId, suffix), nil
+	return fmt.Sprintf(format, licenseKey, editionId, suffix), nil
 }
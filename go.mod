@@ -1,10 +1,11 @@
 module github.com/gjbae1212/go-geoip2
 
-go 1.13
+go 1.16
 
 require (
 	github.com/cenkalti/backoff/v4 v4.0.0
 	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/oschwald/maxminddb-golang v1.6.0
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/sys v0.0.0-20191224085550-c709ea063b76
 )
@@ -0,0 +1,129 @@
+package geoip2
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	geoip2_golang "github.com/oschwald/geoip2-golang"
+)
+
+// lockStore tries to take the store lock, and returns whether this process is the updater of the store.
+// Only the updater downloads databases and writes the store, and other processes reload databases from the store.
+// The lock is per edition, so that processes can update different editions in the same storeDir.
+func (r *downloadReader) lockStore() bool {
+	r.lockMu.Lock()
+	defer r.lockMu.Unlock()
+
+	// closed reader must not take the lock again, because nobody releases it.
+	if r.ctx.Err() != nil {
+		return false
+	}
+	// without storeDir, there is nothing to share.
+	if r.lockFile != nil || r.cfg.storeDir == "" {
+		return true
+	}
+	if err := os.MkdirAll(r.cfg.storeDir, os.ModePerm); err != nil {
+		r.cfg.logger.Warn("geoip2 store lock failed", "edition", r.cfg.editionId, "error", err)
+		return false
+	}
+	f, err := tryLockFile(r.cfg.lockPath())
+	if err != nil {
+		r.cfg.logger.Warn("geoip2 store lock failed", "edition", r.cfg.editionId, "error", err)
+		return false
+	}
+	if f == nil {
+		return false
+	}
+	r.lockFile = f
+	if lockSupported {
+		r.cfg.logger.Info("geoip2 store locked", "edition", r.cfg.editionId)
+	} else {
+		r.cfg.logger.Warn("geoip2 store lock is not supported on this platform, so processes must not share storeDir",
+			"edition", r.cfg.editionId)
+	}
+
+	// clean up staged downloads of crashed updaters.
+	r.cleanStaging()
+	return true
+}
+
+// unlockStore releases the store lock.
+func (r *downloadReader) unlockStore() {
+	r.lockMu.Lock()
+	defer r.lockMu.Unlock()
+
+	if r.lockFile == nil {
+		return
+	}
+	if err := unlockFile(r.lockFile); err != nil {
+		r.cfg.logger.Warn("geoip2 store unlock failed", "edition", r.cfg.editionId, "error", err)
+	}
+	r.lockFile = nil
+}
+
+// updater returns whether this process holds the store lock.
+func (r *downloadReader) updater() bool {
+	r.lockMu.Lock()
+	defer r.lockMu.Unlock()
+
+	return r.lockFile != nil || r.cfg.storeDir == ""
+}
+
+// followUpdate reloads database which the updater stored if it is changed.
+// It only reads the store, because the updater may write it at the same time.
+func (r *downloadReader) followUpdate() (UpdateResult, error) {
+	r.RLock()
+	storeInfo := r.storeInfo
+	checksum := r.cfg.checksum
+	r.RUnlock()
+
+	info, err := os.Stat(r.cfg.dbPath())
+	if err != nil {
+		// the updater has not stored database yet, or is replacing it.
+		return UpdateResult{Checksum: checksum}, nil
+	}
+	if storeInfo != nil && sameFileInfo(storeInfo, info) {
+		// the checksum file may be written after database.
+		if stored := r.storedChecksum(); stored != "" && stored != checksum {
+			checksum = stored
+			r.Lock()
+			r.cfg.checksum = checksum
+			r.cfg.validators = readValidators(r.cfg.validatorsPath())
+			r.Unlock()
+		}
+		r.emit(UpToDate{Edition: r.cfg.editionId, Checksum: checksum})
+		return UpdateResult{Checksum: checksum}, nil
+	}
+
+	db, err := geoip2_golang.Open(r.cfg.dbPath())
+	if err != nil {
+		return UpdateResult{Checksum: checksum}, r.reloadError(r.cfg.dbPath(), err)
+	}
+	checksum = r.storedChecksum()
+
+	r.Lock()
+	var oldEpoch uint
+	if old := r.activeDB(); old != nil {
+		oldEpoch = old.Metadata().BuildEpoch
+	}
+	r.retireDB(r.swapDB(db))
+	r.source = SourceStore
+	r.storeInfo = info
+	r.cfg.checksum = checksum
+	r.cfg.validators = readValidators(r.cfg.validatorsPath())
+	r.setReady()
+	r.Unlock()
+
+	r.emit(Reloaded{Edition: r.cfg.editionId, OldEpoch: oldEpoch, NewEpoch: db.Metadata().BuildEpoch, Checksum: checksum})
+	return UpdateResult{Updated: true, Checksum: checksum}, nil
+}
+
+// storedChecksum returns checksum of the stored database.
+func (r *downloadReader) storedChecksum() string {
+	bys, err := ioutil.ReadFile(r.cfg.checksumPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bys))
+}
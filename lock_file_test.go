@@ -0,0 +1,83 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows
+// +build darwin dragonfly freebsd linux netbsd openbsd windows
+
+package geoip2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTryLockFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-lock")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]struct {
+		path string
+		err  bool
+	}{
+		"success":   {path: filepath.Join(dir, "GeoLite2-Country.lock")},
+		"not-found": {path: filepath.Join(dir, "not-found", "GeoLite2-Country.lock"), err: true},
+	}
+
+	for name, t := range tests {
+		f, err := tryLockFile(t.path)
+		if t.err {
+			assert.Error(err, name)
+			continue
+		}
+		assert.NoError(err, name)
+		assert.NotNil(f, name)
+
+		// the lock is exclusive even in the same process.
+		other, err := tryLockFile(t.path)
+		assert.NoError(err, name)
+		assert.Nil(other, name)
+
+		assert.NoError(unlockFile(f), name)
+		other, err = tryLockFile(t.path)
+		assert.NoError(err, name)
+		assert.NotNil(other, name)
+		assert.NoError(unlockFile(other), name)
+	}
+}
+
+func TestDownloadReader_SharedQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	storeDir, err := ioutil.TempDir("", "geoip2-quota")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	// readers of different editions share the daily budget like processes.
+	var acquired int32
+	var wg sync.WaitGroup
+	for _, edition := range []string{"GeoLite2-Country", "GeoLite2-City", "GeoLite2-ASN", "GeoIP2-ISP"} {
+		reader := newTestDownloadReader(&downloadConfig{editionId: edition, storeDir: storeDir, dailyBudget: 50})
+		defer reader.cancel()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 30; i++ {
+				if reader.acquireRequest("download.maxmind.com", time.Now()) == nil {
+					atomic.AddInt32(&acquired, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(int32(50), atomic.LoadInt32(&acquired))
+	q, err := readQuota(filepath.Join(storeDir, "geoip2.quota"))
+	assert.NoError(err)
+	assert.Equal(50, q["download.maxmind.com"].Requests)
+}
@@ -0,0 +1,38 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package geoip2
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// lockSupported is whether processes can share the store with a lock.
+	lockSupported = false
+)
+
+// tryLockFile opens path without a lock, because advisory locks aren't supported on this platform.
+// So every process updates the store, and lockStore warns it.
+func tryLockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("[err] tryLockFile %w", err)
+	}
+	return f, nil
+}
+
+// lockFile opens path without a lock, because advisory locks aren't supported on this platform.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("[err] lockFile %w", err)
+	}
+	return f, nil
+}
+
+// unlockFile closes the file opened by tryLockFile or lockFile.
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
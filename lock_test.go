@@ -0,0 +1,170 @@
+package geoip2
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadReader_LockStore(t *testing.T) {
+	assert := assert.New(t)
+
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux", "netbsd", "openbsd", "windows":
+	default:
+		t.Skip("advisory lock is not supported")
+	}
+
+	var mu sync.Mutex
+	var requests int32
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 7, "KR")})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		testHandler(archive).ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-lock")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	updater, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)))
+	assert.NoError(err)
+	defer updater.Close()
+	updater.Pause()
+	// wait for the first update.
+	_, err = updater.Update(context.Background())
+	assert.NoError(err)
+	assert.True(updater.Status().Updater)
+	downloaded := atomic.LoadInt32(&requests)
+
+	// the other process only reads the store.
+	follower, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)))
+	assert.NoError(err)
+	defer follower.Close()
+	follower.Pause()
+	status := follower.Status()
+	assert.False(status.Updater)
+	assert.Equal(SourceStore, status.Source)
+	assert.Equal(uint(7), status.BuildEpoch)
+	assert.Equal(fmt.Sprintf("%x", md5.Sum(archive)), status.Checksum)
+
+	result, err := follower.Update(context.Background())
+	assert.NoError(err)
+	assert.False(result.Updated)
+	assert.Equal(downloaded, atomic.LoadInt32(&requests))
+
+	// the follower reloads database which the updater stores.
+	mu.Lock()
+	archive = testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 8, "KR")})
+	mu.Unlock()
+	result, err = updater.Update(context.Background())
+	assert.NoError(err)
+	assert.True(result.Updated)
+	downloaded = atomic.LoadInt32(&requests)
+
+	result, err = follower.Update(context.Background())
+	assert.NoError(err)
+	assert.True(result.Updated)
+	assert.Equal(fmt.Sprintf("%x", md5.Sum(archive)), result.Checksum)
+	assert.Equal(uint(8), follower.Metadata().BuildEpoch)
+	assert.Equal(downloaded, atomic.LoadInt32(&requests))
+	status = follower.Status()
+	assert.Equal(0, status.ConsecutiveFailures)
+	assert.NoError(status.LastError)
+	assert.False(status.LastSuccess.IsZero())
+	assert.Equal(status.LastSuccess, status.LastUpdate)
+
+	// the follower reports a broken store in status, and keeps serving.
+	dbPath := filepath.Join(storeDir, "GeoLite2-Country.mmdb")
+	stored, err := ioutil.ReadFile(dbPath)
+	assert.NoError(err)
+	replace := func(data []byte) {
+		assert.NoError(ioutil.WriteFile(dbPath+".temp", data, 0644))
+		assert.NoError(os.Rename(dbPath+".temp", dbPath))
+	}
+	replace([]byte("invalid"))
+	_, err = follower.Update(context.Background())
+	var rerr *ReloadError
+	assert.True(errors.As(err, &rerr))
+	status = follower.Status()
+	assert.Equal(1, status.ConsecutiveFailures)
+	assert.Error(status.LastError)
+	assert.Equal(uint(8), follower.Metadata().BuildEpoch)
+	replace(stored)
+
+	// the follower takes over the store if the updater is closed.
+	assert.NoError(updater.Close())
+	result, err = follower.Update(context.Background())
+	assert.NoError(err)
+	assert.False(result.Updated)
+	assert.True(follower.Status().Updater)
+	assert.Greater(atomic.LoadInt32(&requests), downloaded)
+
+	// closed reader releases the lock and never takes it again.
+	assert.NoError(follower.Close())
+	_, err = follower.Update(context.Background())
+	assert.Error(err)
+	assert.False(follower.(*downloadReader).lockStore())
+	f, err := tryLockFile(filepath.Join(storeDir, "GeoLite2-Country.lock"))
+	assert.NoError(err)
+	assert.NotNil(f)
+	assert.NoError(unlockFile(f))
+}
+
+func TestDownloadReader_LockStoreCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux", "netbsd", "openbsd", "windows":
+	default:
+		t.Skip("advisory lock is not supported")
+	}
+
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 7, "KR")})
+	server := testServer(archive)
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-lock")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updater, err := OpenURLContext(ctx, "license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)))
+	assert.NoError(err)
+	defer updater.Close()
+	assert.True(updater.Status().Updater)
+
+	follower, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)))
+	assert.NoError(err)
+	defer follower.Close()
+	follower.Pause()
+	assert.False(follower.Status().Updater)
+
+	// the canceled updater releases the lock while it keeps serving, and the other process takes over the store.
+	cancel()
+	<-updater.(*downloadReader).runDownloadDone
+	assert.False(updater.Status().Updater)
+	record, err := updater.Country(net.ParseIP("1.1.1.1"))
+	assert.NoError(err)
+	assert.Equal("KR", record.Country.IsoCode)
+
+	_, err = follower.Update(context.Background())
+	assert.NoError(err)
+	assert.True(follower.Status().Updater)
+}
@@ -0,0 +1,54 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package geoip2
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	// lockSupported is whether processes can share the store with a lock.
+	lockSupported = true
+)
+
+// tryLockFile takes an exclusive advisory lock of path without blocking.
+// It returns nil file if another process holds the lock.
+func tryLockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("[err] tryLockFile %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("[err] tryLockFile %w", err)
+	}
+	return f, nil
+}
+
+// lockFile takes an exclusive advisory lock of path, and waits until another process releases it.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("[err] lockFile %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("[err] lockFile %w", err)
+	}
+	return f, nil
+}
+
+// unlockFile releases the lock taken by tryLockFile or lockFile.
+func unlockFile(f *os.File) error {
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("[err] unlockFile %w", err)
+	}
+	return nil
+}
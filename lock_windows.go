@@ -0,0 +1,60 @@
+//go:build windows
+// +build windows
+
+package geoip2
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// lockSupported is whether processes can share the store with a lock.
+	lockSupported = true
+)
+
+// tryLockFile takes an exclusive lock of path without blocking.
+// It returns nil file if another process holds the lock.
+func tryLockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("[err] tryLockFile %w", err)
+	}
+	if err := lockFileEx(f, windows.LOCKFILE_FAIL_IMMEDIATELY); err != nil {
+		f.Close()
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("[err] tryLockFile %w", err)
+	}
+	return f, nil
+}
+
+// lockFile takes an exclusive lock of path, and waits until another process releases it.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("[err] lockFile %w", err)
+	}
+	if err := lockFileEx(f, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("[err] lockFile %w", err)
+	}
+	return f, nil
+}
+
+// lockFileEx locks the whole range of f exclusively.
+func lockFileEx(f *os.File, flags uint32) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|flags, 0, 1, 0, &windows.Overlapped{})
+}
+
+// unlockFile releases the lock taken by tryLockFile or lockFile.
+func unlockFile(f *os.File) error {
+	defer f.Close()
+	if err := windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{}); err != nil {
+		return fmt.Errorf("[err] unlockFile %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package geoip2
+
+// Logger is a leveled structured logger.
+// keysAndValues are pairs of a field name and its value, so *slog.Logger satisfies Logger.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// nopLogger is a Logger which discards all logs.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// logEvent logs a lifecycle event.
+func logEvent(logger Logger, ev Event) {
+	switch e := ev.(type) {
+	case CheckStarted:
+		logger.Debug("geoip2 check started", "edition", e.Edition)
+	case UpToDate:
+		logger.Debug("geoip2 database is up to date", "edition", e.Edition, "checksum", e.Checksum)
+	case DownloadStarted:
+		logger.Debug("geoip2 download started", "edition", e.Edition, "attempt", e.Attempt)
+	case DownloadFailed:
+		logger.Warn("geoip2 download failed", "edition", e.Edition, "stage", string(e.Stage),
+			"http_status", e.HTTPStatus, "attempt", e.Attempt, "error", e.Err)
+	case Reloaded:
+		logger.Info("geoip2 database reloaded", "edition", e.Edition, "checksum", e.Checksum,
+			"old_epoch", e.OldEpoch, "new_epoch", e.NewEpoch)
+	case RolledBack:
+		logger.Error("geoip2 database rolled back", "edition", e.Edition, "error", e.Err)
+	}
+}
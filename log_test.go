@@ -0,0 +1,88 @@
+package geoip2
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	err := fmt.Errorf("[err] test")
+	tests := map[string]struct {
+		event  Event
+		output testLogEntry
+	}{
+		"check-started": {
+			event:  CheckStarted{Edition: "GeoLite2-Country"},
+			output: testLogEntry{level: "debug", msg: "geoip2 check started", keysAndValues: []interface{}{"edition", "GeoLite2-Country"}},
+		},
+		"up-to-date": {
+			event: UpToDate{Edition: "GeoLite2-Country", Checksum: "abc"},
+			output: testLogEntry{level: "debug", msg: "geoip2 database is up to date",
+				keysAndValues: []interface{}{"edition", "GeoLite2-Country", "checksum", "abc"}},
+		},
+		"download-started": {
+			event: DownloadStarted{Edition: "GeoLite2-Country", Attempt: 2},
+			output: testLogEntry{level: "debug", msg: "geoip2 download started",
+				keysAndValues: []interface{}{"edition", "GeoLite2-Country", "attempt", 2}},
+		},
+		"download-failed": {
+			event: DownloadFailed{Edition: "GeoLite2-Country", Stage: StageChecksum, HTTPStatus: http.StatusNotFound, Attempt: 1, Err: err},
+			output: testLogEntry{level: "warn", msg: "geoip2 download failed",
+				keysAndValues: []interface{}{"edition", "GeoLite2-Country", "stage", "checksum", "http_status", 404, "attempt", 1, "error", err}},
+		},
+		"reloaded": {
+			event: Reloaded{Edition: "GeoLite2-Country", OldEpoch: 1, NewEpoch: 2, Checksum: "abc"},
+			output: testLogEntry{level: "info", msg: "geoip2 database reloaded",
+				keysAndValues: []interface{}{"edition", "GeoLite2-Country", "checksum", "abc", "old_epoch", uint(1), "new_epoch", uint(2)}},
+		},
+		"rolled-back": {
+			event: RolledBack{Edition: "GeoLite2-Country", Err: err},
+			output: testLogEntry{level: "error", msg: "geoip2 database rolled back",
+				keysAndValues: []interface{}{"edition", "GeoLite2-Country", "error", err}},
+		},
+	}
+
+	for _, t := range tests {
+		logger := &testLogger{}
+		logEvent(logger, t.event)
+		assert.Equal([]testLogEntry{t.output}, logger.entries)
+	}
+}
+
+// testLogEntry is a log written to testLogger.
+type testLogEntry struct {
+	level         string
+	msg           string
+	keysAndValues []interface{}
+}
+
+// testLogger is a Logger which keeps logs.
+type testLogger struct {
+	sync.Mutex
+	entries []testLogEntry
+}
+
+func (l *testLogger) log(level, msg string, keysAndValues []interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.entries = append(l.entries, testLogEntry{level: level, msg: msg, keysAndValues: keysAndValues})
+}
+
+func (l *testLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log("debug", msg, keysAndValues)
+}
+func (l *testLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log("info", msg, keysAndValues)
+}
+func (l *testLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log("warn", msg, keysAndValues)
+}
+func (l *testLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, keysAndValues)
+}
@@ -1,8 +1,18 @@
 package geoip2
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
 )
 
 type DownloadOption interface {
@@ -15,16 +25,40 @@ func (dof DownloadOptionFunc) apply(cfg *downloadConfig) { dof(cfg) }
 
 type downloadConfig struct {
 	licenseKey        string
+	accountId         string
 	editionId         string
-	downloadURL       string
-	checksumURL       string
+	downloadFormat    string
+	mirrors           []string
+	downloadSuffix    MaxmindDownloadSuffix
+	checksumSuffix    MaxmindDownloadSuffix
+	maxDatabaseSize   int64
+	downloadURLs      []string
+	checksumURLs      []string
 	storeDir          string
+	watchPath         string
+	stagingDir        string
 	firstDownloadWait time.Duration
+	nonBlocking       bool
 	updateInterval    time.Duration
+	schedule          Schedule
+	jitter            time.Duration
 	retries           int
+	checksumRetries   int
+	downloadRetries   int
+	backoff           backoff.BackOff
+	clock             Clock
+	dailyBudget       int
 	successFunc       func()
 	errorFunc         func(err error)
+	eventFuncs        []func(ev Event)
+	logger            Logger
 	checksum          string
+	validators        validators
+	seed              func() ([]byte, error)
+	httpClient        *http.Client
+	requestTimeout    time.Duration
+	userAgent         string
+	headers           http.Header
 }
 
 // dbPath returns db path.
@@ -39,9 +73,99 @@ func (cfg *downloadConfig) dbBackupPath() string {
 
 // checksumPath returns checksum path.
 func (cfg *downloadConfig) checksumPath() string {
+	if cfg.checksumSuffix == SHA256 {
+		return filepath.Join(cfg.storeDir, cfg.editionId+".sha256")
+	}
 	return filepath.Join(cfg.storeDir, cfg.editionId+".md5")
 }
 
+// stagingPath returns directory where downloads are staged before being moved to db path.
+func (cfg *downloadConfig) stagingPath() string {
+	if cfg.stagingDir != "" {
+		return cfg.stagingDir
+	}
+	return cfg.storeDir
+}
+
+// stagingPattern returns file name pattern of staged downloads.
+func (cfg *downloadConfig) stagingPattern() string {
+	return cfg.editionId + ".mmdb.download-*"
+}
+
+// checksumAttempts returns the number of attempts to request checksum.
+func (cfg *downloadConfig) checksumAttempts() int {
+	return attempts(cfg.checksumRetries, cfg.retries)
+}
+
+// downloadAttempts returns the number of attempts to download database.
+func (cfg *downloadConfig) downloadAttempts() int {
+	return attempts(cfg.downloadRetries, cfg.retries)
+}
+
+// attempts returns retries of a stage if it is set, otherwise default retries. It is at least one.
+func attempts(retries, defaultRetries int) int {
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	if retries <= 0 {
+		retries = 1
+	}
+	return retries
+}
+
+// lockPath returns path of the store lock of edition.
+func (cfg *downloadConfig) lockPath() string {
+	return filepath.Join(cfg.storeDir, cfg.editionId+".lock")
+}
+
+// quotaPath returns path of request quota shared by editions in storeDir.
+func (cfg *downloadConfig) quotaPath() string {
+	return filepath.Join(cfg.storeDir, "geoip2.quota")
+}
+
+// requestHosts returns hosts of the endpoint and mirrors, which have their own quotas.
+func (cfg *downloadConfig) requestHosts() []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, urls := range [][]string{cfg.checksumURLs, cfg.downloadURLs} {
+		for _, rawURL := range urls {
+			u, err := url.Parse(rawURL)
+			if err != nil || seen[u.Host] {
+				continue
+			}
+			seen[u.Host] = true
+			hosts = append(hosts, u.Host)
+		}
+	}
+	return hosts
+}
+
+// quotaLockPath returns path of the lock which processes take to change the quota.
+func (cfg *downloadConfig) quotaLockPath() string {
+	return cfg.quotaPath() + ".lock"
+}
+
+// validatorsPath returns HTTP cache validators path.
+func (cfg *downloadConfig) validatorsPath() string {
+	return filepath.Join(cfg.storeDir, cfg.editionId+".validators")
+}
+
+// checksumURLSuffix returns checksum suffix of the download suffix(e.g. mmdb.gz.sha256 for mmdb.gz).
+func (cfg *downloadConfig) checksumURLSuffix() MaxmindDownloadSuffix {
+	if cfg.downloadSuffix == "" || cfg.downloadSuffix == GZIP {
+		return cfg.checksumSuffix
+	}
+	return cfg.downloadSuffix + MaxmindDownloadSuffix(strings.TrimPrefix(string(cfg.checksumSuffix), string(GZIP)))
+}
+
+// checksumHash returns hash matched to checksum suffix.
+func (cfg *downloadConfig) checksumHash() hash.Hash {
+	if cfg.checksumSuffix == SHA256 {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
 // WithUpdateInterval returns a function for setting download time interval.
 func WithUpdateInterval(d time.Duration) DownloadOptionFunc {
 	return func(cfg *downloadConfig) { cfg.updateInterval = d }
@@ -52,6 +176,48 @@ func WithRetries(retries int) DownloadOptionFunc {
 	return func(cfg *downloadConfig) { cfg.retries = retries }
 }
 
+// WithSchedule returns a function for setting a schedule of background updates.
+// It overrides WithUpdateInterval. e.g. Every(time.Hour), MaxmindReleaseDays() or a schedule of Cron.
+func WithSchedule(schedule Schedule) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.schedule = schedule }
+}
+
+// WithJitter returns a function for delaying each background update by a random duration up to d,
+// so that instances with the same schedule don't request at the same moment.
+func WithJitter(d time.Duration) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.jitter = d }
+}
+
+// WithChecksumRetries returns a function for setting the number of attempts to request checksum.
+// It overrides WithRetries for checksum.
+func WithChecksumRetries(retries int) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.checksumRetries = retries }
+}
+
+// WithDownloadRetries returns a function for setting the number of attempts to download database.
+// It overrides WithRetries for database.
+func WithDownloadRetries(retries int) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.downloadRetries = retries }
+}
+
+// WithBackOff returns a function for setting a backoff policy between retries of a failed attempt.
+// It is reset before each stage of an update, and retries stop if it returns backoff.Stop.
+func WithBackOff(b backoff.BackOff) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.backoff = b }
+}
+
+// WithClock returns a function for setting a clock of the updater.
+func WithClock(clock Clock) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.clock = clock }
+}
+
+// WithDailyBudget returns a function for setting the maximum number of requests to a host in a UTC day.
+// The requests are counted per host in storeDir across restarts and shared by editions in storeDir,
+// so that mirrors on other hosts don't spend the budget of maxmind. Zero means no limit.
+func WithDailyBudget(requests int) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.dailyBudget = requests }
+}
+
 // WithSuccessFunc returns a function for setting a method to call if a download succeeded.
 func WithSuccessFunc(f func()) DownloadOptionFunc {
 	return func(cfg *downloadConfig) { cfg.successFunc = f }
@@ -62,7 +228,129 @@ func WithErrorFunc(f func(error)) DownloadOptionFunc {
 	return func(cfg *downloadConfig) { cfg.errorFunc = f }
 }
 
+// WithEventFunc returns a function for adding a method to call with lifecycle events of updates.
+// It is called synchronously by the updater, so it should not block.
+func WithEventFunc(f func(ev Event)) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.eventFuncs = append(cfg.eventFuncs, f) }
+}
+
+// WithLogger returns a function for setting a logger of internal diagnostics.
+// Logs are discarded if logger is nil.
+func WithLogger(logger Logger) DownloadOptionFunc {
+	return func(cfg *downloadConfig) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		cfg.logger = logger
+	}
+}
+
 // WithFirstDownloadWait returns a function for setting first download wait time.
 func WithFirstDownloadWait(d time.Duration) DownloadOptionFunc {
 	return func(cfg *downloadConfig) { cfg.firstDownloadWait = d }
 }
+
+// WithNonBlocking returns a function for setting whether OpenURL returns without waiting first download.
+// In non-blocking mode, lookups return ErrNotReady until UpdateReader.Ready is closed.
+func WithNonBlocking(nonBlocking bool) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.nonBlocking = nonBlocking }
+}
+
+// WithHTTPClient returns a function for setting http client used by all download requests.
+// A custom transport(proxy, TLS root CAs and so on) can be set through the client.
+func WithHTTPClient(client *http.Client) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.httpClient = client }
+}
+
+// WithRequestTimeout returns a function for setting timeout per a download request.
+// A zero or negative value means no timeout.
+func WithRequestTimeout(d time.Duration) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.requestTimeout = d }
+}
+
+// WithUserAgent returns a function for setting User-Agent header of download requests.
+func WithUserAgent(userAgent string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.userAgent = userAgent }
+}
+
+// WithHeader returns a function for adding an extra header to download requests.
+func WithHeader(key, value string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) {
+		if cfg.headers == nil {
+			cfg.headers = http.Header{}
+		}
+		cfg.headers.Add(key, value)
+	}
+}
+
+// WithDownloadFormat returns a function for overriding download URL template(default MaxmindDownloadFormat).
+// The template is formatted with license key, edition id and suffix in order, like MaxmindDownloadFormat.
+func WithDownloadFormat(format string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.downloadFormat = format }
+}
+
+// WithMirrors returns a function for setting download URL templates which are tried in order
+// when a download from previous URL fails.
+func WithMirrors(formats ...string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.mirrors = append(cfg.mirrors, formats...) }
+}
+
+// WithChecksumSuffix returns a function for setting checksum suffix(MD5 or SHA256) used to verify downloads.
+// The default is MD5, or SHA256 with WithAccountID because the download API doesn't serve MD5.
+func WithChecksumSuffix(suffix MaxmindDownloadSuffix) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.checksumSuffix = suffix }
+}
+
+// WithAccountID returns a function for setting maxmind account id.
+// If it is set, downloads use MaxmindDatabaseDownloadFormat authenticated with account id and license key
+// by HTTP basic auth, unless the download URL template is overridden. Downloads are verified with SHA256 by default.
+func WithAccountID(accountId string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.accountId = accountId }
+}
+
+// WithStagingDir returns a function for setting directory where downloads are staged(default storeDir).
+// It must be on the same filesystem as storeDir, because a staged download is renamed to storeDir.
+func WithStagingDir(dir string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.stagingDir = dir }
+}
+
+// WithMaxDatabaseSize returns a function for setting maximum decompressed size of a downloaded database.
+func WithMaxDatabaseSize(size int64) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.maxDatabaseSize = size }
+}
+
+// WithDownloadSuffix returns a function for setting download artifact suffix(GZIP, MMDB, MMDBGZIP or ZIP).
+// Checksums are requested with the checksum suffix appended to it(e.g. mmdb.gz.md5).
+func WithDownloadSuffix(suffix MaxmindDownloadSuffix) DownloadOptionFunc {
+	return func(cfg *downloadConfig) { cfg.downloadSuffix = suffix }
+}
+
+// WithSeedBytes returns a function for setting a seed database which serves lookups
+// until the first download succeeds, if no database is stored in storeDir.
+// The bytes are used directly, so they must not be modified after.
+func WithSeedBytes(data []byte) DownloadOptionFunc {
+	return func(cfg *downloadConfig) {
+		cfg.seed = func() ([]byte, error) { return data, nil }
+	}
+}
+
+// WithSeedFile returns a function for setting a seed database(or archive) file like WithSeedBytes.
+func WithSeedFile(file string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) {
+		cfg.seed = func() ([]byte, error) {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return readDatabase(f)
+		}
+	}
+}
+
+// WithSeedFS returns a function for setting a seed database(or archive) in fsys(e.g. embed.FS) like WithSeedBytes.
+func WithSeedFS(fsys fs.FS, name string) DownloadOptionFunc {
+	return func(cfg *downloadConfig) {
+		cfg.seed = func() ([]byte, error) { return readDatabaseFS(fsys, name) }
+	}
+}
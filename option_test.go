@@ -1,9 +1,15 @@
 package geoip2
 
 import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	assert "github.com/stretchr/testify/assert"
 )
 
@@ -111,11 +117,25 @@ func TestDownloadConfig_Path(t *testing.T) {
 		dbpath       string
 		dbBackupPath string
 		checksumPath string
+		stagingPath  string
 	}{
 		"success": {input: &downloadConfig{storeDir: "/tmp/geoip2", editionId: "test"},
 			dbpath:       "/tmp/geoip2/test.mmdb",
 			dbBackupPath: "/tmp/geoip2/test.mmdb.backup",
 			checksumPath: "/tmp/geoip2/test.md5",
+			stagingPath:  "/tmp/geoip2",
+		},
+		"sha256": {input: &downloadConfig{storeDir: "/tmp/geoip2", editionId: "test", checksumSuffix: SHA256},
+			dbpath:       "/tmp/geoip2/test.mmdb",
+			dbBackupPath: "/tmp/geoip2/test.mmdb.backup",
+			checksumPath: "/tmp/geoip2/test.sha256",
+			stagingPath:  "/tmp/geoip2",
+		},
+		"staging": {input: &downloadConfig{storeDir: "/tmp/geoip2", editionId: "test", stagingDir: "/tmp/staging"},
+			dbpath:       "/tmp/geoip2/test.mmdb",
+			dbBackupPath: "/tmp/geoip2/test.mmdb.backup",
+			checksumPath: "/tmp/geoip2/test.md5",
+			stagingPath:  "/tmp/staging",
 		},
 	}
 
@@ -123,5 +143,450 @@ func TestDownloadConfig_Path(t *testing.T) {
 		assert.Equal(t.dbpath, t.input.dbPath())
 		assert.Equal(t.dbBackupPath, t.input.dbBackupPath())
 		assert.Equal(t.checksumPath, t.input.checksumPath())
+		assert.Equal(t.stagingPath, t.input.stagingPath())
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &http.Client{}
+	tests := map[string]struct {
+		client *http.Client
+		output *http.Client
+	}{
+		"success": {client: client, output: client},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithHTTPClient(t.client)
+		opt(cfg)
+		assert.Equal(t.output, cfg.httpClient)
+	}
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		timeout time.Duration
+		output  time.Duration
+	}{
+		"success": {timeout: time.Minute, output: time.Minute},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithRequestTimeout(t.timeout)
+		opt(cfg)
+		assert.Equal(t.output, cfg.requestTimeout)
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		userAgent string
+		output    string
+	}{
+		"success": {userAgent: "test-agent", output: "test-agent"},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithUserAgent(t.userAgent)
+		opt(cfg)
+		assert.Equal(t.output, cfg.userAgent)
+	}
+}
+
+func TestWithHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		headers [][2]string
+		output  http.Header
+	}{
+		"success": {headers: [][2]string{{"X-Test", "a"}, {"X-Test", "b"}},
+			output: http.Header{"X-Test": []string{"a", "b"}}},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		for _, h := range t.headers {
+			opt := WithHeader(h[0], h[1])
+			opt(cfg)
+		}
+		assert.Equal(t.output, cfg.headers)
+	}
+}
+
+func TestWithChecksumSuffix(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		suffix MaxmindDownloadSuffix
+		output MaxmindDownloadSuffix
+	}{
+		"success": {suffix: SHA256, output: SHA256},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithChecksumSuffix(t.suffix)
+		opt(cfg)
+		assert.Equal(t.output, cfg.checksumSuffix)
+	}
+}
+
+func TestWithAccountID(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		accountId string
+		output    string
+	}{
+		"success": {accountId: "1234", output: "1234"},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithAccountID(t.accountId)
+		opt(cfg)
+		assert.Equal(t.output, cfg.accountId)
+	}
+}
+
+func TestWithStagingDir(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		dir    string
+		output string
+	}{
+		"success": {dir: "/tmp/staging", output: "/tmp/staging"},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithStagingDir(t.dir)
+		opt(cfg)
+		assert.Equal(t.output, cfg.stagingDir)
+	}
+}
+
+func TestWithMaxDatabaseSize(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		size   int64
+		output int64
+	}{
+		"success": {size: 1 << 20, output: 1 << 20},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithMaxDatabaseSize(t.size)
+		opt(cfg)
+		assert.Equal(t.output, cfg.maxDatabaseSize)
+	}
+}
+
+func TestWithDownloadSuffix(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		suffix         MaxmindDownloadSuffix
+		checksumSuffix MaxmindDownloadSuffix
+		output         MaxmindDownloadSuffix
+		checksumOutput MaxmindDownloadSuffix
+	}{
+		"tar.gz":  {suffix: GZIP, checksumSuffix: MD5, output: GZIP, checksumOutput: MD5},
+		"mmdb":    {suffix: MMDB, checksumSuffix: MD5, output: MMDB, checksumOutput: "mmdb.md5"},
+		"mmdb.gz": {suffix: MMDBGZIP, checksumSuffix: SHA256, output: MMDBGZIP, checksumOutput: "mmdb.gz.sha256"},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{checksumSuffix: t.checksumSuffix}
+		opt := WithDownloadSuffix(t.suffix)
+		opt(cfg)
+		assert.Equal(t.output, cfg.downloadSuffix)
+		assert.Equal(t.checksumOutput, cfg.checksumURLSuffix())
+	}
+}
+
+func TestWithSeedBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		seed   []byte
+		output []byte
+	}{
+		"success": {seed: []byte("seed"), output: []byte("seed")},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithSeedBytes(t.seed)
+		opt(cfg)
+		seed, err := cfg.seed()
+		assert.NoError(err)
+		assert.Equal(t.output, seed)
+	}
+}
+
+func TestWithSeedFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-seed")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	db := testDatabase("GeoLite2-Country", 1, "KR")
+	file := filepath.Join(dir, "seed.tar.gz")
+	assert.NoError(ioutil.WriteFile(file, testArchive(map[string][]byte{"GeoLite2-Country.mmdb": db}), 0644))
+
+	tests := map[string]struct {
+		file   string
+		output []byte
+		isErr  bool
+	}{
+		"success":   {file: file, output: db},
+		"not found": {file: filepath.Join(dir, "notfound.mmdb"), isErr: true},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithSeedFile(t.file)
+		opt(cfg)
+		seed, err := cfg.seed()
+		assert.Equal(t.isErr, err != nil)
+		assert.Equal(t.output, seed)
+	}
+}
+
+func TestWithSeedFS(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testDatabase("GeoLite2-Country", 1, "KR")
+	fsys := fstest.MapFS{"seed/GeoLite2-Country.mmdb": {Data: db}}
+
+	tests := map[string]struct {
+		name   string
+		output []byte
+		isErr  bool
+	}{
+		"success":   {name: "seed/GeoLite2-Country.mmdb", output: db},
+		"not found": {name: "notfound.mmdb", isErr: true},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithSeedFS(fsys, t.name)
+		opt(cfg)
+		seed, err := cfg.seed()
+		assert.Equal(t.isErr, err != nil)
+		assert.Equal(t.output, seed)
+	}
+}
+
+func TestWithNonBlocking(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		nonBlocking bool
+		output      bool
+	}{
+		"success": {nonBlocking: true, output: true},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithNonBlocking(t.nonBlocking)
+		opt(cfg)
+		assert.Equal(t.output, cfg.nonBlocking)
+	}
+}
+
+func TestWithEventFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		funcs  int
+		output int
+	}{
+		"one":  {funcs: 1, output: 1},
+		"many": {funcs: 3, output: 3},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		called := 0
+		for i := 0; i < t.funcs; i++ {
+			opt := WithEventFunc(func(ev Event) { called++ })
+			opt(cfg)
+		}
+		for _, f := range cfg.eventFuncs {
+			f(CheckStarted{})
+		}
+		assert.Equal(t.output, called)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := &testLogger{}
+	tests := map[string]struct {
+		logger Logger
+		output Logger
+	}{
+		"logger": {logger: logger, output: logger},
+		"nil":    {logger: nil, output: nopLogger{}},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithLogger(t.logger)
+		opt(cfg)
+		assert.Equal(t.output, cfg.logger)
+	}
+}
+
+func TestWithDailyBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		requests int
+		output   int
+	}{
+		"success":   {requests: 1000, output: 1000},
+		"unlimited": {requests: 0, output: 0},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithDailyBudget(t.requests)
+		opt(cfg)
+		assert.Equal(t.output, cfg.dailyBudget)
+	}
+}
+
+func TestWithChecksumRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		retries         int
+		checksumRetries int
+		output          int
+	}{
+		"stage":   {retries: 2, checksumRetries: 5, output: 5},
+		"default": {retries: 2, output: 2},
+		"min":     {output: 1},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{retries: t.retries}
+		opt := WithChecksumRetries(t.checksumRetries)
+		opt(cfg)
+		assert.Equal(t.checksumRetries, cfg.checksumRetries)
+		assert.Equal(t.output, cfg.checksumAttempts())
+	}
+}
+
+func TestWithDownloadRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		retries         int
+		downloadRetries int
+		output          int
+	}{
+		"stage":   {retries: 2, downloadRetries: 5, output: 5},
+		"default": {retries: 2, output: 2},
+		"min":     {output: 1},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{retries: t.retries}
+		opt := WithDownloadRetries(t.downloadRetries)
+		opt(cfg)
+		assert.Equal(t.downloadRetries, cfg.downloadRetries)
+		assert.Equal(t.output, cfg.downloadAttempts())
+	}
+}
+
+func TestWithBackOff(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		backoff backoff.BackOff
+	}{
+		"zero":     {backoff: &backoff.ZeroBackOff{}},
+		"constant": {backoff: backoff.NewConstantBackOff(time.Second)},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithBackOff(t.backoff)
+		opt(cfg)
+		assert.Equal(t.backoff, cfg.backoff)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newTestClock(time.Now())
+	tests := map[string]struct {
+		clock Clock
+	}{
+		"test": {clock: clock},
+		"real": {clock: realClock{}},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithClock(t.clock)
+		opt(cfg)
+		assert.Equal(t.clock, cfg.clock)
+	}
+}
+
+func TestWithSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		schedule Schedule
+	}{
+		"every":   {schedule: Every(time.Hour)},
+		"release": {schedule: MaxmindReleaseDays()},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithSchedule(t.schedule)
+		opt(cfg)
+		assert.Equal(t.schedule, cfg.schedule)
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		jitter time.Duration
+		output time.Duration
+	}{
+		"success": {jitter: time.Minute, output: time.Minute},
+	}
+
+	for _, t := range tests {
+		cfg := &downloadConfig{}
+		opt := WithJitter(t.jitter)
+		opt(cfg)
+		assert.Equal(t.output, cfg.jitter)
 	}
 }
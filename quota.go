@@ -0,0 +1,207 @@
+package geoip2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// quota is the number of requests to a host in a UTC day and the time until requests to the host are suspended.
+type quota struct {
+	Day          string    `json:"day"`
+	Requests     int       `json:"requests"`
+	SuspendUntil time.Time `json:"suspend_until"`
+}
+
+// quotas are quotas by host. They are stored in storeDir and shared by all editions in storeDir,
+// because maxmind counts downloads per account. Mirrors on other hosts have their own quotas.
+type quotas map[string]quota
+
+// readQuota reads quotas from path. If path doesn't exist, it returns empty quotas.
+func readQuota(path string) (quotas, error) {
+	q := quotas{}
+	bys, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return q, fmt.Errorf("[err] readQuota %w", err)
+	}
+	if err := json.Unmarshal(bys, &q); err != nil {
+		return q, fmt.Errorf("[err] readQuota %w", err)
+	}
+	return q, nil
+}
+
+// write writes quotas to path. It is written to a temporary file and renamed,
+// so that other processes never read a partial file.
+func (q quotas) write(path string) error {
+	bys, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("[err] quota write %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("[err] quota write %w", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("[err] quota write %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bys); err != nil {
+		f.Close()
+		return fmt.Errorf("[err] quota write %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("[err] quota write %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("[err] quota write %w", err)
+	}
+	return nil
+}
+
+// lockQuota takes quotaMu and the quota file lock shared by processes using storeDir,
+// so that quota is loaded, changed and saved at once. It returns a function to unlock.
+func (r *downloadReader) lockQuota() func() {
+	r.quotaMu.Lock()
+	if r.cfg.storeDir == "" {
+		return r.quotaMu.Unlock
+	}
+	if err := os.MkdirAll(r.cfg.storeDir, os.ModePerm); err != nil {
+		r.cfg.logger.Warn("geoip2 quota lock failed", "edition", r.cfg.editionId, "error", err)
+		return r.quotaMu.Unlock
+	}
+	f, err := lockFile(r.cfg.quotaLockPath())
+	if err != nil {
+		r.cfg.logger.Warn("geoip2 quota lock failed", "edition", r.cfg.editionId, "error", err)
+		return r.quotaMu.Unlock
+	}
+	return func() {
+		if err := unlockFile(f); err != nil {
+			r.cfg.logger.Warn("geoip2 quota unlock failed", "edition", r.cfg.editionId, "error", err)
+		}
+		r.quotaMu.Unlock()
+	}
+}
+
+// loadQuota loads quota stored in storeDir. It must be called with quotaMu held.
+// If the stored quota is broken, the last loaded quota is kept rather than reset.
+func (r *downloadReader) loadQuota() {
+	if r.quota == nil {
+		r.quota = quotas{}
+	}
+	if r.cfg.storeDir == "" {
+		return
+	}
+	q, err := readQuota(r.cfg.quotaPath())
+	if err != nil {
+		r.cfg.logger.Warn("geoip2 quota read failed", "edition", r.cfg.editionId, "error", err)
+		return
+	}
+	r.quota = q
+}
+
+// saveQuota stores quota in storeDir. It must be called with quotaMu held.
+func (r *downloadReader) saveQuota() {
+	if r.cfg.storeDir != "" {
+		if err := r.quota.write(r.cfg.quotaPath()); err != nil {
+			r.cfg.logger.Warn("geoip2 quota write failed", "edition", r.cfg.editionId, "error", err)
+		}
+	}
+}
+
+// acquireRequest counts a request to host at now.
+// It returns an error if requests to host are suspended or the daily budget of host is exhausted.
+func (r *downloadReader) acquireRequest(host string, now time.Time) error {
+	unlock := r.lockQuota()
+	defer unlock()
+
+	r.loadQuota()
+	q := r.quota[host]
+	if now.Before(q.SuspendUntil) {
+		return fmt.Errorf("[err] acquireRequest %s until %s %w", host, q.SuspendUntil.UTC().Format(time.RFC3339), ErrThrottled)
+	}
+	day := now.UTC().Format("2006-01-02")
+	if q.Day != day {
+		q.Day = day
+		q.Requests = 0
+	}
+	if r.cfg.dailyBudget > 0 && q.Requests >= r.cfg.dailyBudget {
+		return fmt.Errorf("[err] acquireRequest %s %d requests %w", host, q.Requests, ErrQuotaExceeded)
+	}
+	q.Requests++
+	r.quota[host] = q
+	r.saveQuota()
+	return nil
+}
+
+// suspendRequests suspends requests to host until t.
+func (r *downloadReader) suspendRequests(host string, t time.Time) {
+	unlock := r.lockQuota()
+	defer unlock()
+
+	r.loadQuota()
+	if q := r.quota[host]; t.After(q.SuspendUntil) {
+		q.SuspendUntil = t
+		r.quota[host] = q
+		r.saveQuota()
+	}
+	r.cfg.logger.Warn("geoip2 requests suspended", "edition", r.cfg.editionId, "host", host, "until", t)
+}
+
+// suspendedUntil returns the time until requests to all hosts of the endpoint and mirrors are suspended.
+// It returns the zero time if any of them is not suspended.
+func (r *downloadReader) suspendedUntil() time.Time {
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+
+	r.loadQuota()
+	return r.suspendedUntilLocked(r.cfg.clock.Now())
+}
+
+// suspendedUntilLocked is suspendedUntil at now. It must be called with quotaMu held.
+func (r *downloadReader) suspendedUntilLocked(now time.Time) time.Time {
+	var until time.Time
+	for _, host := range r.cfg.requestHosts() {
+		q := r.quota[host]
+		if !now.Before(q.SuspendUntil) {
+			return time.Time{}
+		}
+		if until.IsZero() || q.SuspendUntil.Before(until) {
+			until = q.SuspendUntil
+		}
+	}
+	return until
+}
+
+// throttled returns whether err is caused by HTTP 429, suspended requests or the exhausted daily budget.
+// Retrying such an error only spends the quota.
+func throttled(err error) bool {
+	var derr *DownloadError
+	if errors.As(err, &derr) && derr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return errors.Is(err, ErrThrottled) || errors.Is(err, ErrQuotaExceeded)
+}
+
+// retryAfter returns the time to retry from Retry-After header value at now.
+// If value is empty or invalid, it returns the start of the next UTC day when a daily quota is reset.
+func retryAfter(value string, now time.Time) time.Time {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t
+	}
+	day := now.UTC()
+	return time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,263 @@
+package geoip2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		value  string
+		output time.Time
+	}{
+		"seconds":  {value: "120", output: now.Add(2 * time.Minute)},
+		"date":     {value: "Wed, 01 Jan 2020 12:00:00 GMT", output: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)},
+		"empty":    {value: "", output: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		"invalid":  {value: "soon", output: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		"negative": {value: "-1", output: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, t := range tests {
+		assert.True(t.output.Equal(retryAfter(t.value, now)))
+	}
+}
+
+func TestDownloadReader_DailyBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, "checksum")
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-quota")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	newReader := func() *downloadReader {
+		return newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+			checksumURLs: []string{server.URL}, dailyBudget: 2})
+	}
+
+	reader := newReader()
+	for i := 0; i < 2; i++ {
+		_, err := reader.downloadChecksum(context.Background(), 1)
+		assert.NoError(err)
+	}
+	_, err = reader.downloadChecksum(context.Background(), 1)
+	assert.True(errors.Is(err, ErrQuotaExceeded))
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(2, reader.Status().RequestsToday)
+	reader.cancel()
+
+	// the budget is shared across restarts.
+	reader = newReader()
+	_, err = reader.downloadChecksum(context.Background(), 1)
+	assert.True(errors.Is(err, ErrQuotaExceeded))
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+	reader.cancel()
+
+	// the budget is reset in the next UTC day.
+	u, err := url.Parse(server.URL)
+	assert.NoError(err)
+	assert.NoError(quotas{u.Host: {Day: "2020-01-01", Requests: 2}}.write(reader.cfg.quotaPath()))
+	reader = newReader()
+	_, err = reader.downloadChecksum(context.Background(), 1)
+	assert.NoError(err)
+	assert.Equal(int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(1, reader.Status().RequestsToday)
+	reader.cancel()
+}
+
+func TestDownloadReader_TooManyRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-quota")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	newReader := func() *downloadReader {
+		return newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+			checksumURLs: []string{server.URL, server.URL}, retries: 3})
+	}
+
+	reader := newReader()
+	before := time.Now()
+	_, err = reader.update(context.Background())
+	assert.True(errors.Is(err, ErrThrottled))
+	// neither mirrors on the same host nor retries are requested after 429.
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	until := reader.Status().SuspendedUntil
+	assert.False(until.Before(before.Add(time.Hour)))
+	assert.False(until.After(time.Now().Add(time.Hour)))
+
+	_, err = reader.update(context.Background())
+	assert.True(errors.Is(err, ErrThrottled))
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	reader.cancel()
+	reader.unlockStore()
+
+	// the suspension is shared across restarts.
+	reader = newReader()
+	_, err = reader.update(context.Background())
+	assert.True(errors.Is(err, ErrThrottled))
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	reader.cancel()
+	reader.unlockStore()
+}
+
+func TestDownloadReader_TooManyRequestsMirror(t *testing.T) {
+	assert := assert.New(t)
+
+	var primaryRequests, mirrorRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&primaryRequests, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer primary.Close()
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 1, "KR")})
+	handler := testHandler(archive)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&mirrorRequests, 1)
+		handler.ServeHTTP(w, req)
+	}))
+	defer mirror.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-quota")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	urls := func(suffix MaxmindDownloadSuffix) []string {
+		return []string{fmt.Sprintf(testFormat(primary), "license-key", "GeoLite2-Country", suffix),
+			fmt.Sprintf(testFormat(mirror), "license-key", "GeoLite2-Country", suffix)}
+	}
+	reader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+		downloadURLs: urls(GZIP), checksumURLs: urls(MD5), checksumSuffix: MD5, retries: 1, dailyBudget: 3})
+	defer func() {
+		reader.cancel()
+		reader.unlockStore()
+		if db := reader.activeDB(); db != nil {
+			db.Close()
+		}
+	}()
+
+	// 429 without Retry-After suspends only the primary until the next UTC day, and the mirror serves the update.
+	result, err := reader.update(context.Background())
+	assert.NoError(err)
+	assert.True(result.Updated)
+	assert.Equal(int32(1), atomic.LoadInt32(&primaryRequests))
+	assert.Equal(int32(2), atomic.LoadInt32(&mirrorRequests))
+	assert.True(reader.Status().SuspendedUntil.IsZero())
+
+	// the primary isn't requested while it is suspended, and requests to it don't spend the budget of the mirror.
+	_, err = reader.update(context.Background())
+	assert.NoError(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&primaryRequests))
+	assert.Equal(int32(3), atomic.LoadInt32(&mirrorRequests))
+
+	q, err := readQuota(reader.cfg.quotaPath())
+	assert.NoError(err)
+	u, err := url.Parse(primary.URL)
+	assert.NoError(err)
+	assert.Equal(1, q[u.Host].Requests)
+	assert.True(q[u.Host].SuspendUntil.After(time.Now()))
+	u, err = url.Parse(mirror.URL)
+	assert.NoError(err)
+	assert.Equal(3, q[u.Host].Requests)
+	assert.True(q[u.Host].SuspendUntil.IsZero())
+}
+
+func TestQuota_Write(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-quota")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]struct {
+		data   []byte
+		input  quotas
+		output quotas
+		err    bool
+	}{
+		"not-found": {output: quotas{}},
+		"success": {input: quotas{"download.maxmind.com": {Day: "2020-01-01", Requests: 3}, "mirror.example.com": {Requests: 1}},
+			output: quotas{"download.maxmind.com": {Day: "2020-01-01", Requests: 3}, "mirror.example.com": {Requests: 1}}},
+		"broken": {data: []byte(`{"download.maxmind.com":{"day":"2020-01-01","requ`), err: true},
+	}
+
+	for name, t := range tests {
+		path := filepath.Join(dir, name, "geoip2.quota")
+		switch {
+		case t.data != nil:
+			assert.NoError(os.MkdirAll(filepath.Dir(path), os.ModePerm), name)
+			assert.NoError(ioutil.WriteFile(path, t.data, 0644), name)
+		case t.input != nil:
+			assert.NoError(t.input.write(path), name)
+		}
+
+		q, err := readQuota(path)
+		assert.Equal(t.err, err != nil, name)
+		if !t.err {
+			assert.Equal(t.output, q, name)
+		}
+
+		// a temporary file isn't left.
+		files, _ := filepath.Glob(filepath.Join(dir, name, "*"))
+		if t.data == nil && t.input == nil {
+			assert.Empty(files, name)
+		} else {
+			assert.Equal([]string{path}, files, name)
+		}
+	}
+}
+
+func TestDownloadReader_BrokenQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	storeDir, err := ioutil.TempDir("", "geoip2-quota")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	logger := &testLogger{}
+	reader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+		dailyBudget: 2, logger: logger})
+	defer reader.cancel()
+
+	now := time.Now()
+	assert.NoError(reader.acquireRequest("download.maxmind.com", now))
+	assert.NoError(reader.acquireRequest("download.maxmind.com", now))
+
+	// a broken quota file doesn't reset the budget.
+	assert.NoError(ioutil.WriteFile(reader.cfg.quotaPath(), []byte("{"), 0644))
+	assert.True(errors.Is(reader.acquireRequest("download.maxmind.com", now), ErrQuotaExceeded))
+	logger.Lock()
+	assert.Equal("warn", logger.entries[0].level)
+	assert.Equal("geoip2 quota read failed", logger.entries[0].msg)
+	logger.Unlock()
+}
@@ -1,18 +1,23 @@
 package geoip2
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	backoff "github.com/cenkalti/backoff/v4"
@@ -26,167 +31,626 @@ type fileReader struct {
 
 type downloadReader struct {
 	sync.RWMutex
-	db               *geoip2_golang.Reader
-	cfg              *downloadConfig
-	runDownloadClose chan bool
-	backoff          *backoff.ExponentialBackOff
+	// db is the active *database, which lookups read without the lock.
+	db              atomic.Value
+	source          Source
+	cfg             *downloadConfig
+	ctx             context.Context
+	cancel          context.CancelFunc
+	runDownloadDone chan struct{}
+	ready           chan struct{}
+	readyOnce       sync.Once
+	pause           bool
+	updateMu        sync.Mutex
+	status          Status
+	quotaMu         sync.Mutex
+	quota           quotas
+	backoff         backoff.BackOff
+	rand            *rand.Rand
+	lockMu          sync.Mutex
+	lockFile        *os.File
+	storeInfo       os.FileInfo
+	failedInfo      os.FileInfo
+	watchErr        error
+}
+
+const (
+	// followInterval is an interval for processes other than the updater to check the store.
+	followInterval = time.Minute
+	// followWaitInterval is followInterval until the updater stores database first.
+	followWaitInterval = time.Second
+)
+
+// newDownloadReader returns downloadReader whose updater is not started.
+func newDownloadReader(ctx context.Context, cfg *downloadConfig) *downloadReader {
+	ctx, cancel := context.WithCancel(ctx)
+	return &downloadReader{
+		ctx:             ctx,
+		cancel:          cancel,
+		runDownloadDone: make(chan struct{}),
+		ready:           make(chan struct{}),
+		cfg:             cfg,
+		backoff:         cfg.backoff,
+		// instances have different jitter.
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
 }
 
 // ASN is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) ASN(ipAddress net.IP) (*geoip2_golang.ASN, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.ASN(ipAddress)
+	return db.ASN(ipAddress)
 }
 
 // AnonymousIP is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) AnonymousIP(ipAddress net.IP) (*geoip2_golang.AnonymousIP, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.AnonymousIP(ipAddress)
+	return db.AnonymousIP(ipAddress)
 }
 
 // City is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) City(ipAddress net.IP) (*geoip2_golang.City, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.City(ipAddress)
+	return db.City(ipAddress)
 }
 
 // ConnectionType is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) ConnectionType(ipAddress net.IP) (*geoip2_golang.ConnectionType, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.ConnectionType(ipAddress)
+	return db.ConnectionType(ipAddress)
 }
 
 // Country is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) Country(ipAddress net.IP) (*geoip2_golang.Country, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.Country(ipAddress)
+	return db.Country(ipAddress)
 }
 
 // Domain is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) Domain(ipAddress net.IP) (*geoip2_golang.Domain, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.Domain(ipAddress)
+	return db.Domain(ipAddress)
 }
 
 // Enterprise is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) Enterprise(ipAddress net.IP) (*geoip2_golang.Enterprise, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.Enterprise(ipAddress)
+	return db.Enterprise(ipAddress)
 }
 
 // ISP is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) ISP(ipAddress net.IP) (*geoip2_golang.ISP, error) {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return nil, ErrNotReady
+	}
+	defer db.release()
 
-	return r.db.ISP(ipAddress)
+	return db.ISP(ipAddress)
 }
 
 // Metadata is the same method as that "github.com/oschwald/geoip2-golang" is.
 func (r *downloadReader) Metadata() maxminddb.Metadata {
-	r.RLock()
-	defer r.RUnlock()
+	db := r.acquireDB()
+	if db == nil {
+		return maxminddb.Metadata{}
+	}
+	defer db.release()
 
-	return r.db.Metadata()
+	return db.Metadata()
 }
 
 // Close is the same method as that "github.com/oschwald/geoip2-golang" is.
+// It stops the background updater and waits for it to exit, and waits for lookups in progress before closing database.
 func (r *downloadReader) Close() error {
+	r.cancel()
+	<-r.runDownloadDone
+	// wait for a manual update in progress.
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+	r.unlockStore()
+
 	r.Lock()
-	defer r.Unlock()
-	close(r.runDownloadClose)
+	old := r.swapDB(nil)
+	r.source = SourceNone
+	r.Unlock()
+	if old == nil {
+		return nil
+	}
+	old.release()
+	return old.wait()
+}
+
+// Source returns where the active database comes from.
+func (r *downloadReader) Source() Source {
+	r.RLock()
+	defer r.RUnlock()
 
-	return r.db.Close()
+	return r.source
+}
+
+// Ready returns a channel which is closed when a database is loaded first.
+func (r *downloadReader) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// WaitReady waits until a database is loaded first. It returns ErrNotReady if reader is closed before.
+func (r *downloadReader) WaitReady(ctx context.Context) error {
+	select {
+	case <-r.ready:
+		return nil
+	default:
+	}
+
+	select {
+	case <-r.ready:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("[err] WaitReady %w", ctx.Err())
+	case <-r.ctx.Done():
+		return fmt.Errorf("[err] WaitReady %w", ErrNotReady)
+	}
+}
+
+// setReady marks reader ready.
+func (r *downloadReader) setReady() {
+	r.readyOnce.Do(func() { close(r.ready) })
+}
+
+// loaded returns whether database is loaded.
+func (r *downloadReader) loaded() bool {
+	return r.activeDB() != nil
+}
+
+// sleep waits for d, and returns false if ctx is done before.
+func (r *downloadReader) sleep(ctx context.Context, d time.Duration) bool {
+	t := r.cfg.clock.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C():
+		return true
+	}
 }
 
 func (r *downloadReader) runDownloadURL() {
-	for {
-		// getting checksum
-		var remoteChecksum string
-		for i := 0; i < r.cfg.retries; i++ {
-			// wait for backoff interval.
-			time.Sleep(r.backoff.NextBackOff())
+	defer close(r.runDownloadDone)
+	// release the store lock when ctx is canceled, so that another process takes over the store
+	// while reader is still alive. A manual update in progress finishes first.
+	defer func() {
+		r.updateMu.Lock()
+		defer r.updateMu.Unlock()
+		r.unlockStore()
+	}()
 
-			c, err := r.downloadChecksum()
-			if err != nil {
-				r.cfg.errorFunc(fmt.Errorf("[err] runDownloadURL %w", err))
-				continue
-			}
-			remoteChecksum = strings.TrimSpace(c)
+	for {
+		// skip update while paused, or while requests of the updater are suspended.
+		suspended := r.lockStore() && r.cfg.clock.Now().Before(r.suspendedUntil())
+		if !r.paused() && !suspended {
+			r.update(r.ctx)
 		}
-		// reset backoff.
-		r.backoff.Reset()
 
-		if remoteChecksum == "" {
-			r.cfg.errorFunc(fmt.Errorf("[err] runDownloadURL checksum download fail"))
+		now := r.cfg.clock.Now()
+		var next time.Time
+		if r.updater() {
+			next = r.nextCheck(now)
+			if next.IsZero() {
+				r.Lock()
+				r.status.NextCheck = next
+				r.Unlock()
+				// no more background updates.
+				<-r.ctx.Done()
+				return
+			}
+			// wait until requests are resumed if they are suspended longer than the schedule.
+			if until := r.suspendedUntil(); until.After(next) {
+				next = until
+			}
 		} else {
-			// if local checksum is equal to remote checksum, updating maxmind database.
-			if remoteChecksum != r.cfg.checksum {
-				for i := 0; i < r.cfg.retries; i++ {
-					// wait for backoff interval.
-					time.Sleep(r.backoff.NextBackOff())
-
-					// downloading database.
-					tempPath, err := r.downloadDatabase()
-					if err != nil {
-						r.cfg.errorFunc(fmt.Errorf("[err] runDownloadURL %w", err))
-						continue
-					}
-
-					// reload new database.
-					if err := r.databaseReload(tempPath, remoteChecksum); err != nil {
-						r.cfg.errorFunc(fmt.Errorf("[err] runDownloadURL %w", err))
-						continue
-					}
-
-					// call a success function.
-					r.cfg.successFunc()
-					break
-				}
-				// reset backoff.
-				r.backoff.Reset()
-			} else {
-				fmt.Println("[pass][geoip2] remote-checksum equals local-checksum.")
+			// other processes check the store often to reload database soon after the updater stores it.
+			next = now.Add(followInterval)
+			if !r.loaded() {
+				next = now.Add(followWaitInterval)
 			}
 		}
+		r.Lock()
+		r.status.NextCheck = next
+		r.Unlock()
+
+		if !r.sleep(r.ctx, next.Sub(now)) {
+			return
+		}
+	}
+}
+
+// nextCheck returns the next time to check after now by the schedule and jitter.
+func (r *downloadReader) nextCheck(now time.Time) time.Time {
+	schedule := r.cfg.schedule
+	if schedule == nil {
+		schedule = Every(r.cfg.updateInterval)
+	}
+	next := schedule.Next(now)
+	if !next.IsZero() && r.cfg.jitter > 0 {
+		next = next.Add(time.Duration(r.rand.Int63n(int64(r.cfg.jitter))))
+	}
+	return next
+}
+
+// Status is a status of the updater.
+type Status struct {
+	// Edition is maxmind edition id.
+	Edition string
+	// Source is where the active database comes from.
+	Source Source
+	// Checksum is checksum of the active database.
+	Checksum string
+	// Path is file path of the active database. It is empty if the database isn't stored in storeDir.
+	Path string
+	// BuildEpoch is build time of the active database as Unix epoch time.
+	BuildEpoch uint
+	// Paused is whether background updates are paused.
+	Paused bool
+	// LastCheck is the time when the last update started.
+	LastCheck time.Time
+	// LastSuccess is the time when an update succeeded last, whether a new database is loaded or not.
+	LastSuccess time.Time
+	// LastUpdate is the time when a new database is loaded last.
+	LastUpdate time.Time
+	// LastError is an error of the last update, and nil if it succeeded.
+	LastError error
+	// ConsecutiveFailures is the number of updates failed in a row.
+	ConsecutiveFailures int
+	// NextCheck is the time when the next background update is scheduled.
+	NextCheck time.Time
+	// Updater is whether this process holds the store lock and downloads databases.
+	// Otherwise it reloads databases which the updater stores.
+	Updater bool
+	// RequestsToday is the number of requests to all hosts in the current UTC day counted in storeDir.
+	RequestsToday int
+	// SuspendedUntil is the time until requests to the endpoint and all mirrors are suspended by HTTP 429.
+	SuspendedUntil time.Time
+}
+
+// Status returns a status of the updater.
+func (r *downloadReader) Status() Status {
+	r.RLock()
+	defer r.RUnlock()
+
+	status := r.status
+	status.Edition = r.cfg.editionId
+	status.Source = r.source
+	status.Paused = r.pause
+	status.Updater = r.updater()
+	if db := r.activeDB(); db != nil {
+		status.Checksum = r.cfg.checksum
+		status.BuildEpoch = db.Metadata().BuildEpoch
+	}
+	switch r.source {
+	case SourceStore, SourceDownload:
+		status.Path = r.cfg.dbPath()
+	case SourceFile:
+		status.Path = r.cfg.watchPath
+	}
+
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+	r.loadQuota()
+	now := r.cfg.clock.Now()
+	for _, q := range r.quota {
+		if q.Day == now.UTC().Format("2006-01-02") {
+			status.RequestsToday += q.Requests
+		}
+	}
+	status.SuspendedUntil = r.suspendedUntilLocked(now)
+	return status
+}
+
+// UpdateResult is a result of an update.
+type UpdateResult struct {
+	// Updated is whether a new database is loaded.
+	Updated bool
+	// Checksum is checksum of the active database.
+	Checksum string
+}
 
+// Update checks and downloads the latest database immediately.
+// It is serialized with background updates, and works even while updates are paused.
+func (r *downloadReader) Update(ctx context.Context) (UpdateResult, error) {
+	// cancel an update if reader is closed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
 		select {
-		case <-r.runDownloadClose:
+		case <-r.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	result, err := r.update(ctx)
+	if err != nil {
+		return result, fmt.Errorf("[err] Update %w", err)
+	}
+	return result, nil
+}
+
+// Pause pauses background updates.
+func (r *downloadReader) Pause() {
+	r.Lock()
+	defer r.Unlock()
+	r.pause = true
+}
+
+// Resume resumes background updates paused by Pause.
+func (r *downloadReader) Resume() {
+	r.Lock()
+	defer r.Unlock()
+	r.pause = false
+}
+
+// paused returns whether background updates are paused.
+func (r *downloadReader) paused() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.pause
+}
+
+// update checks and downloads the latest database once.
+func (r *downloadReader) update(ctx context.Context) (result UpdateResult, err error) {
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+
+	// closed reader must not load database again.
+	if r.ctx.Err() != nil {
+		return UpdateResult{}, fmt.Errorf("[err] update %w", ErrNotReady)
+	}
+
+	r.Lock()
+	r.status.LastCheck = r.cfg.clock.Now()
+	r.Unlock()
+	r.emit(CheckStarted{Edition: r.cfg.editionId})
+
+	// record status of every kind of update, including a watched file and a follower.
+	defer func() {
+		r.Lock()
+		defer r.Unlock()
+		r.status.LastError = err
+		if err != nil {
+			r.status.ConsecutiveFailures++
 			return
-		case <-time.After(r.cfg.updateInterval):
+		}
+		r.status.ConsecutiveFailures = 0
+		r.status.LastSuccess = r.cfg.clock.Now()
+		if result.Updated {
+			r.status.LastUpdate = r.status.LastSuccess
+		}
+	}()
+
+	// a watched file has no store.
+	if r.cfg.watchPath != "" {
+		return r.watchUpdate()
+	}
+
+	// only the updater of the store downloads database.
+	if !r.lockStore() {
+		return r.followUpdate()
+	}
+
+	// if validators of the last download exist, checking update with a conditional request.
+	if r.cfg.validators.empty() {
+		return r.checksumUpdate(ctx)
+	}
+	return r.conditionalUpdate(ctx)
+}
+
+// retry calls f with attempt numbers up to attempts times until f succeeds.
+// It waits for the backoff interval only before retrying a failed attempt, and stops retrying
+// if the backoff stops, ctx is done or f fails because of the quota.
+func (r *downloadReader) retry(ctx context.Context, attempts int, f func(attempt int) error) error {
+	r.backoff.Reset()
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			// wait for backoff interval.
+			d := r.backoff.NextBackOff()
+			if d == backoff.Stop {
+				break
+			}
+			if !r.sleep(ctx, d) {
+				return fmt.Errorf("[err] retry %w", ctx.Err())
+			}
+		}
+		if err = f(attempt); err == nil || throttled(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// checksumUpdate downloads database if remote checksum is different from local checksum.
+func (r *downloadReader) checksumUpdate(ctx context.Context) (UpdateResult, error) {
+	// getting checksum
+	var remoteChecksum string
+	if err := r.retry(ctx, r.cfg.checksumAttempts(), func(attempt int) error {
+		c, err := r.downloadChecksum(ctx, attempt)
+		if err != nil {
+			err = fmt.Errorf("[err] runDownloadURL %w", err)
+			r.downloadFailed(StageChecksum, attempt, err)
+			return err
+		}
+		remoteChecksum = c
+		return nil
+	}); err != nil {
+		return UpdateResult{Checksum: r.cfg.checksum}, fmt.Errorf("[err] runDownloadURL checksum download fail %w", err)
+	}
+
+	// if local checksum is equal to remote checksum, skipping update.
+	if remoteChecksum == r.cfg.checksum {
+		r.emit(UpToDate{Edition: r.cfg.editionId, Checksum: r.cfg.checksum})
+		return UpdateResult{Checksum: r.cfg.checksum}, nil
+	}
+
+	if err := r.retry(ctx, r.cfg.downloadAttempts(), func(attempt int) error {
+		// downloading database.
+		r.emit(DownloadStarted{Edition: r.cfg.editionId, Attempt: attempt})
+		download, err := r.downloadDatabase(ctx, attempt, remoteChecksum, validators{})
+		if err != nil {
+			err = fmt.Errorf("[err] runDownloadURL %w", err)
+			r.downloadFailed(StageDownload, attempt, err)
+			return err
+		}
+
+		// reload new database.
+		if err := r.databaseReload(download.tempPath, remoteChecksum, download.validators); err != nil {
+			err = fmt.Errorf("[err] runDownloadURL %w", err)
+			r.downloadFailed(StageReload, attempt, err)
+			return err
+		}
+		return nil
+	}); err != nil {
+		return UpdateResult{Checksum: r.cfg.checksum}, err
+	}
+	return UpdateResult{Updated: true, Checksum: remoteChecksum}, nil
+}
+
+// conditionalUpdate downloads database only if it is modified since the last download.
+// checksum is requested only to verify a newly downloaded database, so a whole attempt is retried with the download budget.
+func (r *downloadReader) conditionalUpdate(ctx context.Context) (UpdateResult, error) {
+	result := UpdateResult{Checksum: r.cfg.checksum}
+	err := r.retry(ctx, r.cfg.downloadAttempts(), func(attempt int) error {
+		// downloading database if modified.
+		r.emit(DownloadStarted{Edition: r.cfg.editionId, Attempt: attempt})
+		download, err := r.downloadDatabase(ctx, attempt, "", r.cfg.validators)
+		if err != nil {
+			err = fmt.Errorf("[err] runDownloadURL %w", err)
+			r.downloadFailed(StageDownload, attempt, err)
+			return err
+		}
+		if download.notModified {
+			r.cfg.logger.Debug("geoip2 remote database is not modified", "edition", r.cfg.editionId)
+			r.emit(UpToDate{Edition: r.cfg.editionId, Checksum: r.cfg.checksum})
+			return nil
+		}
+
+		// verify new database.
+		remoteChecksum, err := r.downloadChecksum(ctx, attempt)
+		if err != nil {
+			os.Remove(download.tempPath)
+			err = fmt.Errorf("[err] runDownloadURL %w", err)
+			r.downloadFailed(StageChecksum, attempt, err)
+			return err
+		}
+		if !strings.EqualFold(remoteChecksum, download.checksum) {
+			os.Remove(download.tempPath)
+			err = fmt.Errorf("[err] runDownloadURL %w",
+				&ChecksumMismatchError{Expected: remoteChecksum, Actual: download.checksum})
+			r.downloadFailed(StageVerify, attempt, err)
+			return err
+		}
+
+		// if local checksum is equal to remote checksum, only remembering new validators.
+		if remoteChecksum == r.cfg.checksum {
+			os.Remove(download.tempPath)
+			r.cfg.validators = download.validators
+			r.cfg.validators.write(r.cfg.validatorsPath())
+			r.emit(UpToDate{Edition: r.cfg.editionId, Checksum: r.cfg.checksum})
+			return nil
+		}
+
+		// reload new database.
+		if err := r.databaseReload(download.tempPath, remoteChecksum, download.validators); err != nil {
+			err = fmt.Errorf("[err] runDownloadURL %w", err)
+			r.downloadFailed(StageReload, attempt, err)
+			return err
+		}
+		result = UpdateResult{Updated: true, Checksum: remoteChecksum}
+		return nil
+	})
+	return result, err
+}
+
+// cleanStaging removes leftover files of crashed runs, and restores backup database if db path is missing.
+func (r *downloadReader) cleanStaging() {
+	if matches, err := filepath.Glob(filepath.Join(r.cfg.stagingPath(), r.cfg.stagingPattern())); err == nil {
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}
+
+	dbpath := r.cfg.dbPath()
+	dbBackupPath := r.cfg.dbBackupPath()
+	if _, err := os.Stat(dbBackupPath); err == nil {
+		if _, err := os.Stat(dbpath); os.IsNotExist(err) {
+			os.Rename(dbBackupPath, dbpath)
+		} else {
+			os.Remove(dbBackupPath)
 		}
 	}
 }
 
 // databaseReload reloads maxmind database.
-func (r *downloadReader) databaseReload(tempPath, checksum string) error {
+// If checksum is empty, checksum and validators are read from files of the stored database.
+func (r *downloadReader) databaseReload(tempPath, checksum string, v validators) error {
 	if tempPath == "" {
-		return fmt.Errorf("[err] databaseReload %w", ErrInvalidParameters)
+		return r.reloadError(tempPath, ErrInvalidParameters)
 	}
 	if _, err := os.Stat(tempPath); os.IsNotExist(err) {
-		return fmt.Errorf("[err] databaseReload %w", ErrNotFoundDatabase)
+		return r.reloadError(tempPath, ErrNotFoundDatabase)
 	}
+
+	// emit an event after the lock is released.
+	var ev Event
+	defer func() {
+		if ev != nil {
+			r.emit(ev)
+		}
+	}()
 	r.Lock()
 	defer r.Unlock()
 
 	// make directory.
 	if _, err := os.Stat(r.cfg.storeDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(r.cfg.storeDir, os.ModePerm); err != nil {
-			return fmt.Errorf("[err] databaseReload %w", err)
+			return r.reloadError(tempPath, err)
 		}
 	}
 
@@ -194,10 +658,11 @@ func (r *downloadReader) databaseReload(tempPath, checksum string) error {
 	dbpath := r.cfg.dbPath()
 	dbBackupPath := r.cfg.dbBackupPath()
 	checksumPath := r.cfg.checksumPath()
+	backup := false
 	if info, err := os.Stat(dbpath); info != nil || os.IsExist(err) {
 		if dbpath != tempPath {
 			// make backup old database
-			os.Rename(dbpath, dbBackupPath)
+			backup = os.Rename(dbpath, dbBackupPath) == nil
 		}
 	}
 
@@ -207,7 +672,11 @@ func (r *downloadReader) databaseReload(tempPath, checksum string) error {
 		os.RemoveAll(tempPath)
 		// rollback old database
 		os.Rename(dbBackupPath, dbpath)
-		return fmt.Errorf("[err] databaseReload %w", err)
+		err = r.reloadError(tempPath, err)
+		if backup {
+			ev = RolledBack{Edition: r.cfg.editionId, Err: err}
+		}
+		return err
 	}
 
 	// open new database.
@@ -217,7 +686,11 @@ func (r *downloadReader) databaseReload(tempPath, checksum string) error {
 		os.RemoveAll(dbpath)
 		// rollback old database
 		os.Rename(dbBackupPath, dbpath)
-		return fmt.Errorf("[err] databaseReload %w", err)
+		err = r.reloadError(tempPath, err)
+		if backup {
+			ev = RolledBack{Edition: r.cfg.editionId, Err: err}
+		}
+		return err
 	}
 
 	// delete back old database
@@ -225,50 +698,237 @@ func (r *downloadReader) databaseReload(tempPath, checksum string) error {
 		os.RemoveAll(dbBackupPath)
 	}
 
-	// release old database
-	if r.db != nil {
-		if err := r.db.Close(); err != nil {
-			fmt.Printf("[err] databaseReload old database close %v", err)
-		}
-		r.db = nil
-		r.cfg.checksum = ""
+	var oldEpoch uint
+	if old := r.activeDB(); old != nil {
+		oldEpoch = old.Metadata().BuildEpoch
 	}
 
+	source := SourceDownload
 	if checksum == "" {
+		source = SourceStore
 		// read md5 file.
 		if bys, err := ioutil.ReadFile(checksumPath); err == nil {
-			checksum = string(bys)
+			checksum = strings.TrimSpace(string(bys))
 		}
+		// read validators file.
+		v = readValidators(r.cfg.validatorsPath())
 	} else {
 		// write md5 to file.
 		if cpath, err := os.Create(r.cfg.checksumPath()); err == nil {
 			cpath.WriteString(checksum)
 			cpath.Close()
 		}
+		// write validators to file.
+		v.write(r.cfg.validatorsPath())
+		ev = Reloaded{Edition: r.cfg.editionId, OldEpoch: oldEpoch, NewEpoch: db.Metadata().BuildEpoch, Checksum: checksum}
 	}
 
-	r.db = db
+	// swap database, and release old database after lookups using it.
+	r.retireDB(r.swapDB(db))
+	r.source = source
 	r.cfg.checksum = checksum
+	r.cfg.validators = v
+	r.setReady()
 	return nil
 }
 
-// requestChecksum requests checksum data.
-func (r *downloadReader) downloadChecksum() (checksum string, err error) {
-	resp, suberr := http.Get(r.cfg.checksumURL)
-	if resp != nil {
-		defer resp.Body.Close()
-	} else {
-		err = fmt.Errorf("[err] downloadChecksum resp nil")
-		return
+// reloadError returns err as *ReloadError of database path.
+func (r *downloadReader) reloadError(path string, err error) error {
+	return &ReloadError{Edition: r.cfg.editionId, Path: path, Err: fmt.Errorf("[err] databaseReload %w", err)}
+}
+
+// seedLoad loads seed database.
+func (r *downloadReader) seedLoad() error {
+	data, err := r.cfg.seed()
+	if err != nil {
+		return fmt.Errorf("[err] seedLoad %w", err)
+	}
+	db, err := geoip2_golang.FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("[err] seedLoad %w", err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.retireDB(r.swapDB(db))
+	r.source = SourceSeed
+	r.setReady()
+	return nil
+}
+
+// validators are HTTP cache validators of the last downloaded database.
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// readValidators reads validators from path, and returns empty validators if it fails.
+func readValidators(path string) validators {
+	var v validators
+	bys, err := ioutil.ReadFile(path)
+	if err != nil {
+		return validators{}
+	}
+	if err := json.Unmarshal(bys, &v); err != nil {
+		return validators{}
+	}
+	return v
+}
+
+// empty returns whether validators don't exist.
+func (v validators) empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// write writes validators to path, and removes path if validators are empty.
+func (v validators) write(path string) error {
+	if v.empty() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	bys, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bys, 0644)
+}
+
+// requestContext returns a context for a download request which is bounded by the request timeout.
+func (r *downloadReader) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.cfg.requestTimeout > 0 {
+		return context.WithTimeout(ctx, r.cfg.requestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// request sends a GET request applied download options.
+// The request is conditional if validators are not empty, and a returned error has no license key.
+func (r *downloadReader) request(ctx context.Context, rawURL string, v validators) (resp *http.Response, err error) {
+	defer func() {
+		if err != nil {
+			err = r.redactError(err)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	for key, values := range r.cfg.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if r.cfg.userAgent != "" {
+		req.Header.Set("User-Agent", r.cfg.userAgent)
+	}
+
+	client := r.cfg.httpClient
+	if client == nil {
+		client = http.DefaultClient
 	}
+	if r.cfg.accountId != "" {
+		req.SetBasicAuth(r.cfg.accountId, r.cfg.licenseKey)
+		client = withoutRedirectAuth(client)
+	}
+
+	// count requests and suspend them if the host asks.
+	if err := r.acquireRequest(req.URL.Host, r.cfg.clock.Now()); err != nil {
+		return nil, err
+	}
+	resp, err = client.Do(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		r.suspendRequests(req.URL.Host, retryAfter(resp.Header.Get("Retry-After"), r.cfg.clock.Now()))
+	}
+	return resp, err
+}
+
+// downloadError returns err as *DownloadError of op and attempt.
+func (r *downloadReader) downloadError(op Stage, attempt int, err error) error {
+	var derr *DownloadError
+	if errors.As(err, &derr) {
+		derr.Attempt = attempt
+		return err
+	}
+	return &DownloadError{Op: op, Edition: r.cfg.editionId, Attempt: attempt, Err: err}
+}
+
+// redactError removes license key from URL of err.
+func (r *downloadReader) redactError(err error) error {
+	var uerr *url.Error
+	if !errors.As(err, &uerr) {
+		return err
+	}
+	uerr.URL = redact(Redacted(uerr.URL), r.cfg.licenseKey)
+	if r.cfg.licenseKey != "" && strings.Contains(uerr.Err.Error(), r.cfg.licenseKey) {
+		uerr.Err = errors.New(redact(uerr.Err.Error(), r.cfg.licenseKey))
+	}
+	return err
+}
+
+// withoutRedirectAuth returns a copy of client which doesn't send credentials to redirect targets on other hosts.
+// maxmind redirects a download to a presigned URL which must not receive the license key.
+func withoutRedirectAuth(client *http.Client) *http.Client {
+	c := *client
+	checkRedirect := client.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != via[0].URL.Host {
+			req.Header.Del("Authorization")
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("[err] withoutRedirectAuth stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &c
+}
+
+// downloadChecksum requests checksum data from the endpoint and mirrors in order.
+// attempt is reported with failures of mirrors except the last.
+func (r *downloadReader) downloadChecksum(ctx context.Context, attempt int) (checksum string, err error) {
+	for i, url := range r.cfg.checksumURLs {
+		if i > 0 {
+			r.downloadFailed(StageChecksum, attempt, fmt.Errorf("[err] downloadChecksum try next mirror %w", err))
+		}
+		if checksum, err = r.downloadChecksumFrom(ctx, url); err == nil {
+			return
+		}
+		// a throttled host doesn't stop mirrors on other hosts.
+		err = r.downloadError(StageChecksum, attempt, err)
+	}
+	if err == nil {
+		err = fmt.Errorf("[err] downloadChecksum %w", ErrInvalidParameters)
+	}
+	return
+}
+
+// downloadChecksumFrom requests checksum data from url.
+func (r *downloadReader) downloadChecksumFrom(ctx context.Context, url string) (checksum string, err error) {
+	ctx, cancel := r.requestContext(ctx)
+	defer cancel()
+
+	resp, suberr := r.request(ctx, url, validators{})
 	if suberr != nil {
 		err = fmt.Errorf("[err] downloadChecksum %w", suberr)
 		return
 	}
+	defer resp.Body.Close()
 
 	status := resp.StatusCode
 	if resp.StatusCode/100 != 2 {
-		err = fmt.Errorf("[err] downloadChecksum status %d", status)
+		err = &DownloadError{Op: StageChecksum, Edition: r.cfg.editionId, StatusCode: status,
+			Err: fmt.Errorf("[err] downloadChecksum status %d", status)}
 		return
 
 	}
@@ -279,79 +939,124 @@ func (r *downloadReader) downloadChecksum() (checksum string, err error) {
 		return
 	}
 
-	checksum = string(data)
+	// checksum file contains a hex digest optionally followed by a file name.
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		err = fmt.Errorf("[err] downloadChecksum empty checksum")
+		return
+	}
+
+	checksum = strings.ToLower(fields[0])
 	return
 }
 
-// request requests checksum data.
-func (r *downloadReader) downloadDatabase() (tempPath string, err error) {
-	// download database
-	resp, suberr := http.Get(r.cfg.downloadURL)
-	if resp != nil {
-		defer resp.Body.Close()
-	} else {
-		err = fmt.Errorf("[err] downloadDatabase resp nil")
-		return
+// databaseDownload is a result of a database download.
+type databaseDownload struct {
+	tempPath    string
+	checksum    string
+	validators  validators
+	notModified bool
+}
+
+// downloadDatabase downloads database from the endpoint and mirrors in order.
+// The downloaded archive is verified with checksum if checksum is not empty,
+// and the download is skipped if database is not modified since validators.
+// attempt is reported with failures of mirrors except the last.
+func (r *downloadReader) downloadDatabase(ctx context.Context, attempt int, checksum string, v validators) (download *databaseDownload, err error) {
+	for i, url := range r.cfg.downloadURLs {
+		if i > 0 {
+			r.downloadFailed(StageDownload, attempt, fmt.Errorf("[err] downloadDatabase try next mirror %w", err))
+		}
+		if download, err = r.downloadDatabaseFrom(ctx, url, checksum, v); err == nil {
+			return
+		}
+		// a throttled host doesn't stop mirrors on other hosts.
+		err = r.downloadError(StageDownload, attempt, err)
+	}
+	if err == nil {
+		err = fmt.Errorf("[err] downloadDatabase %w", ErrInvalidParameters)
 	}
+	return
+}
+
+// downloadDatabaseFrom downloads database from url to temporary path.
+func (r *downloadReader) downloadDatabaseFrom(ctx context.Context, url, checksum string, v validators) (download *databaseDownload, err error) {
+	// download database
+	ctx, cancel := r.requestContext(ctx)
+	defer cancel()
+
+	resp, suberr := r.request(ctx, url, v)
 	if suberr != nil {
 		err = fmt.Errorf("[err] downloadDatabase %w", suberr)
 		return
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		download = &databaseDownload{validators: v, notModified: true}
+		return
+	}
 
 	status := resp.StatusCode
 	if resp.StatusCode/100 != 2 {
-		err = fmt.Errorf("[err] downloadDatabase status %d", status)
+		err = &DownloadError{Op: StageDownload, Edition: r.cfg.editionId, StatusCode: status,
+			Err: fmt.Errorf("[err] downloadDatabase status %d", status)}
 		return
 	}
 
-	// save database to temporary path
-	fpath := filepath.Join(os.TempDir(), fmt.Sprintf("maxmind-%d.mmdb", time.Now().UnixNano()))
-	f, suberr := os.Create(fpath)
+	// save database to staging path which is on the same filesystem as db path.
+	if suberr := os.MkdirAll(r.cfg.stagingPath(), os.ModePerm); suberr != nil {
+		err = fmt.Errorf("[err] downloadDatabase %w", suberr)
+		return
+	}
+	f, suberr := ioutil.TempFile(r.cfg.stagingPath(), r.cfg.stagingPattern())
 	if suberr != nil {
 		err = fmt.Errorf("[err] downloadDatabase %w", suberr)
 		return
 	}
-	defer f.Close()
+	fpath := f.Name()
+	defer func() {
+		f.Close()
+		// delete staged database if a download is failed.
+		if err != nil {
+			os.Remove(fpath)
+		}
+	}()
 
-	// wrapping unzip reader
-	gr, suberr := gzip.NewReader(resp.Body)
-	if suberr != nil {
+	// hashing the whole archive while reading it.
+	hash := r.cfg.checksumHash()
+	body := io.TeeReader(&limitedReader{r: resp.Body, n: r.cfg.maxDatabaseSize}, hash)
+
+	// extract database from archive.
+	if suberr := extractDatabase(f, body, r.cfg.downloadSuffix, r.cfg.editionId, r.cfg.maxDatabaseSize); suberr != nil {
 		err = fmt.Errorf("[err] downloadDatabase %w", suberr)
 		return
 	}
-	defer gr.Close()
 
-	// wrapping tar reader
-	tr := tar.NewReader(gr)
+	// flush staged database to disk before it is renamed.
+	if suberr := f.Sync(); suberr != nil {
+		err = fmt.Errorf("[err] downloadDatabase %w", suberr)
+		return
+	}
 
-Search:
-	for {
-		header, suberr := tr.Next()
-		// check error
-		switch {
-		case suberr == io.EOF:
-			err = fmt.Errorf("[err] downloadDatabase not found mmdb in gzip.")
-			return
-		case suberr != nil:
-			err = fmt.Errorf("[err] downloadDatabase read gzip %w", suberr)
-			return
-		case header == nil:
-			continue
-		}
-
-		// search mmdb
-		switch header.Typeflag {
-		case tar.TypeReg:
-			if strings.HasSuffix(strings.ToLower(header.Name), "mmdb") {
-				if _, suberr := io.Copy(f, gr); suberr != nil {
-					err = fmt.Errorf("[err] downloadDatabase read gzip %w", suberr)
-					return
-				}
-				break Search
-			}
-		}
+	// verify checksum of the whole archive.
+	if _, suberr := io.Copy(ioutil.Discard, body); suberr != nil {
+		err = fmt.Errorf("[err] downloadDatabase read gzip %w", suberr)
+		return
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && !strings.EqualFold(checksum, actual) {
+		err = fmt.Errorf("[err] downloadDatabase %w", &ChecksumMismatchError{Expected: checksum, Actual: actual})
+		return
 	}
 
-	tempPath = fpath
+	download = &databaseDownload{
+		tempPath: fpath,
+		checksum: actual,
+		validators: validators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		},
+	}
 	return
 }
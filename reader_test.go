@@ -1,18 +1,28 @@
 package geoip2
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	geoip2_golang "github.com/oschwald/geoip2-golang"
+	maxminddb "github.com/oschwald/maxminddb-golang"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -310,3 +320,771 @@ func BenchmarkDownloadReader_Country(b *testing.B) {
 		}
 	}
 }
+
+// testMutexReader is a reader guarded by RWMutex, which holds the write lock while it reloads database.
+type testMutexReader struct {
+	sync.RWMutex
+	db *geoip2_golang.Reader
+}
+
+func (r *testMutexReader) Country(ipAddress net.IP) (*geoip2_golang.Country, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.db.Country(ipAddress)
+}
+
+func (r *testMutexReader) reload(data []byte) {
+	r.Lock()
+	defer r.Unlock()
+	db, err := geoip2_golang.FromBytes(data)
+	if err != nil {
+		panic(err)
+	}
+	r.db.Close()
+	r.db = db
+}
+
+// BenchmarkReader_ParallelCountry compares parallel lookups of RWMutex and atomic swapping while database is reloaded.
+func BenchmarkReader_ParallelCountry(b *testing.B) {
+	data := testDatabase("GeoLite2-Country", 1, "KR")
+	open := func() *geoip2_golang.Reader {
+		db, err := geoip2_golang.FromBytes(data)
+		if err != nil {
+			panic(err)
+		}
+		return db
+	}
+
+	mutexReader := &testMutexReader{db: open()}
+	atomicReader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country"})
+	defer atomicReader.cancel()
+	atomicReader.retireDB(atomicReader.swapDB(open()))
+
+	tests := map[string]struct {
+		country func(net.IP) (*geoip2_golang.Country, error)
+		reload  func()
+	}{
+		"rwmutex": {country: mutexReader.Country, reload: func() { mutexReader.reload(data) }},
+		"atomic": {country: atomicReader.Country, reload: func() {
+			db := open()
+			atomicReader.Lock()
+			defer atomicReader.Unlock()
+			atomicReader.retireDB(atomicReader.swapDB(db))
+		}},
+	}
+
+	for name, t := range tests {
+		for _, reloading := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/reloading=%t", name, reloading), func(b *testing.B) {
+				done := make(chan struct{})
+				var wg sync.WaitGroup
+				if reloading {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							select {
+							case <-done:
+								return
+							default:
+								t.reload()
+							}
+						}
+					}()
+				}
+
+				ip := net.ParseIP("1.1.1.1")
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if _, err := t.country(ip); err != nil {
+							panic(err)
+						}
+					}
+				})
+				b.StopTimer()
+				close(done)
+				wg.Wait()
+			})
+		}
+	}
+}
+
+func TestDownloadReader_CloseWaitsUpdater(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		updateInterval time.Duration
+	}{
+		"success": {updateInterval: time.Hour},
+	}
+
+	for _, t := range tests {
+		reader := newTestDownloadReader(&downloadConfig{updateInterval: t.updateInterval, retries: 1})
+		go reader.runDownloadURL()
+
+		assert.NoError(reader.Close())
+		select {
+		case <-reader.runDownloadDone:
+		default:
+			assert.Fail("updater is still running after Close")
+		}
+	}
+}
+
+func TestDownloadReader_Request(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/hang" {
+			<-req.Context().Done()
+			return
+		}
+		fmt.Fprintf(w, "%s|%s", req.Header.Get("User-Agent"), req.Header.Get("X-Test"))
+	}))
+	defer server.Close()
+
+	tests := map[string]struct {
+		url      string
+		timeout  time.Duration
+		checksum string
+		isErr    bool
+	}{
+		"success": {url: server.URL, checksum: "test-agent|header"},
+		"timeout": {url: server.URL + "/hang", timeout: 100 * time.Millisecond, isErr: true},
+	}
+
+	for _, t := range tests {
+		reader := newTestDownloadReader(&downloadConfig{
+			checksumURLs:   []string{t.url},
+			requestTimeout: t.timeout,
+			userAgent:      "test-agent",
+			headers:        http.Header{"X-Test": []string{"header"}},
+		})
+		checksum, err := reader.downloadChecksum(context.Background(), 1)
+		assert.Equal(t.isErr, err != nil)
+		assert.Equal(t.checksum, checksum)
+		reader.cancel()
+	}
+}
+
+func TestDownloadReader_DownloadDatabase(t *testing.T) {
+	assert := assert.New(t)
+
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 1, "KR")})
+	server := testServer(archive)
+	defer server.Close()
+	url := fmt.Sprintf(testFormat(server), "license-key", "GeoLite2-Country", GZIP)
+
+	tests := map[string]struct {
+		suffix   MaxmindDownloadSuffix
+		checksum string
+		mismatch bool
+	}{
+		"md5":      {suffix: MD5, checksum: fmt.Sprintf("%x", md5.Sum(archive))},
+		"sha256":   {suffix: SHA256, checksum: fmt.Sprintf("%x", sha256.Sum256(archive))},
+		"mismatch": {suffix: SHA256, checksum: fmt.Sprintf("%x", sha256.Sum256(nil)), mismatch: true},
+	}
+
+	storeDir, err := ioutil.TempDir("", "geoip2-download")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	for _, t := range tests {
+		reader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+			downloadURLs: []string{url}, checksumSuffix: t.suffix})
+		download, err := reader.downloadDatabase(context.Background(), 1, t.checksum, validators{})
+		var mismatchErr *ChecksumMismatchError
+		assert.Equal(t.mismatch, errors.As(err, &mismatchErr))
+		if err == nil {
+			assert.Equal(t.checksum, download.checksum)
+			assert.Equal(storeDir, filepath.Dir(download.tempPath))
+			os.Remove(download.tempPath)
+		} else {
+			assert.Nil(download)
+		}
+		reader.cancel()
+
+		// staged downloads must not be left.
+		matches, _ := filepath.Glob(filepath.Join(storeDir, reader.cfg.stagingPattern()))
+		assert.Empty(matches)
+	}
+}
+
+func TestDownloadReader_ConditionalUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 1, "KR")})
+	etag := fmt.Sprintf("\"%x\"", md5.Sum(archive))
+	var mu sync.Mutex
+	requests := map[MaxmindDownloadSuffix]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		suffix := MaxmindDownloadSuffix(req.URL.Query().Get("suffix"))
+		mu.Lock()
+		requests[suffix]++
+		mu.Unlock()
+		switch suffix {
+		case GZIP:
+			if req.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write(archive)
+		case MD5:
+			fmt.Fprintf(w, "%x", md5.Sum(archive))
+		}
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-conditional")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	reader, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)))
+	assert.NoError(err)
+	assert.NoError(reader.Close())
+	assert.Equal(validators{ETag: etag}, readValidators(filepath.Join(storeDir, "GeoLite2-Country.validators")))
+
+	// restarted reader remembers validators and the update is skipped without checksum request.
+	r := newTestDownloadReader(&downloadConfig{
+		editionId:      "GeoLite2-Country",
+		storeDir:       storeDir,
+		downloadURLs:   []string{fmt.Sprintf(testFormat(server), "license-key", "GeoLite2-Country", GZIP)},
+		checksumURLs:   []string{fmt.Sprintf(testFormat(server), "license-key", "GeoLite2-Country", MD5)},
+		retries:        1,
+		checksumSuffix: MD5,
+	})
+	assert.NoError(r.databaseReload(r.cfg.dbPath(), "", validators{}))
+	assert.Equal(etag, r.cfg.validators.ETag)
+
+	mu.Lock()
+	requests = map[MaxmindDownloadSuffix]int{}
+	mu.Unlock()
+	r.conditionalUpdate(context.Background())
+	mu.Lock()
+	assert.Equal(map[MaxmindDownloadSuffix]int{GZIP: 1}, requests)
+	mu.Unlock()
+	r.cancel()
+	r.activeDB().Close()
+}
+
+func TestDownloadReader_CleanStaging(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		files  []string
+		output []string
+	}{
+		"staging": {files: []string{"test.mmdb", "test.mmdb.download-1", "test.mmdb.download-2", "other.mmdb.download-1"},
+			output: []string{"other.mmdb.download-1", "test.mmdb"}},
+		"zip spool": {files: []string{"test.mmdb", "test.mmdb.download-1", "test.mmdb.download-1-2.zip"},
+			output: []string{"test.mmdb"}},
+		"backup":      {files: []string{"test.mmdb", "test.mmdb.backup"}, output: []string{"test.mmdb"}},
+		"restore":     {files: []string{"test.mmdb.backup"}, output: []string{"test.mmdb"}},
+		"nothing":     {files: []string{}, output: []string{}},
+		"only backup": {files: []string{"test.md5", "test.mmdb.backup"}, output: []string{"test.md5", "test.mmdb"}},
+	}
+
+	for _, t := range tests {
+		storeDir, err := ioutil.TempDir("", "geoip2-staging")
+		assert.NoError(err)
+
+		for _, file := range t.files {
+			assert.NoError(ioutil.WriteFile(filepath.Join(storeDir, file), []byte(file), 0644))
+		}
+		reader := newTestDownloadReader(&downloadConfig{editionId: "test", storeDir: storeDir})
+		reader.cleanStaging()
+
+		infos, err := ioutil.ReadDir(storeDir)
+		assert.NoError(err)
+		files := []string{}
+		for _, info := range infos {
+			files = append(files, info.Name())
+		}
+		assert.Equal(t.output, files)
+		os.RemoveAll(storeDir)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-validators")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.validators")
+
+	tests := map[string]struct {
+		input validators
+	}{
+		"etag":          {input: validators{ETag: `"etag"`}},
+		"last-modified": {input: validators{LastModified: "Wed, 01 Jan 2020 00:00:00 GMT"}},
+		"empty":         {input: validators{}},
+	}
+
+	for _, t := range tests {
+		assert.NoError(t.input.write(path))
+		assert.Equal(t.input, readValidators(path))
+	}
+}
+
+func TestDownloadReader_NotReady(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := newTestDownloadReader(&downloadConfig{})
+	defer reader.cancel()
+	ip := net.ParseIP("8.8.8.8")
+
+	tests := map[string]struct {
+		lookup func() error
+	}{
+		"ASN":            {lookup: func() error { _, err := reader.ASN(ip); return err }},
+		"AnonymousIP":    {lookup: func() error { _, err := reader.AnonymousIP(ip); return err }},
+		"City":           {lookup: func() error { _, err := reader.City(ip); return err }},
+		"ConnectionType": {lookup: func() error { _, err := reader.ConnectionType(ip); return err }},
+		"Country":        {lookup: func() error { _, err := reader.Country(ip); return err }},
+		"Domain":         {lookup: func() error { _, err := reader.Domain(ip); return err }},
+		"Enterprise":     {lookup: func() error { _, err := reader.Enterprise(ip); return err }},
+		"ISP":            {lookup: func() error { _, err := reader.ISP(ip); return err }},
+	}
+
+	for k, t := range tests {
+		assert.True(errors.Is(t.lookup(), ErrNotReady), k)
+	}
+	assert.Equal(maxminddb.Metadata{}, reader.Metadata())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(errors.Is(reader.WaitReady(ctx), context.DeadlineExceeded))
+	reader.cancel()
+	assert.True(errors.Is(reader.WaitReady(context.Background()), ErrNotReady))
+}
+
+func TestDownloadReader_Update(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 1, "KR")})
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		testHandler(archive).ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-update")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	reader, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)),
+		WithUpdateInterval(50*time.Millisecond))
+	assert.NoError(err)
+
+	// paused reader doesn't request in background.
+	reader.Pause()
+	time.Sleep(time.Second)
+	mu.Lock()
+	paused := requests
+	mu.Unlock()
+	time.Sleep(time.Second)
+	mu.Lock()
+	assert.Equal(paused, requests)
+	mu.Unlock()
+
+	tests := map[string]struct {
+		archive []byte
+		updated bool
+	}{
+		"up to date": {updated: false},
+		"updated": {archive: testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 2, "US")}),
+			updated: true},
+	}
+
+	for _, k := range []string{"up to date", "updated"} {
+		t := tests[k]
+		if t.archive != nil {
+			mu.Lock()
+			archive = t.archive
+			mu.Unlock()
+		}
+		result, err := reader.Update(context.Background())
+		assert.NoError(err, k)
+		assert.Equal(t.updated, result.Updated, k)
+		mu.Lock()
+		assert.Equal(fmt.Sprintf("%x", md5.Sum(archive)), result.Checksum, k)
+		mu.Unlock()
+	}
+	assert.Equal(uint(2), reader.Metadata().BuildEpoch)
+
+	// resumed reader requests in background again.
+	mu.Lock()
+	paused = requests
+	mu.Unlock()
+	reader.Resume()
+	time.Sleep(2 * time.Second)
+	mu.Lock()
+	assert.True(requests > paused)
+	mu.Unlock()
+
+	// closed reader doesn't load a new database.
+	mu.Lock()
+	archive = testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 3, "KR")})
+	mu.Unlock()
+	assert.NoError(reader.Close())
+	result, err := reader.Update(context.Background())
+	assert.True(errors.Is(err, ErrNotReady))
+	assert.False(result.Updated)
+	assert.Equal(SourceNone, reader.Source())
+	assert.Equal(uint(0), reader.Metadata().BuildEpoch)
+}
+
+func TestDownloadReader_Status(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	fail := false
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 7, "KR")})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		testHandler(archive).ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	storeDir, err := ioutil.TempDir("", "geoip2-status")
+	assert.NoError(err)
+	defer os.RemoveAll(storeDir)
+
+	before := time.Now()
+	reader, err := OpenURL("license-key", "GeoLite2-Country", storeDir, WithDownloadFormat(testFormat(server)))
+	assert.NoError(err)
+	defer reader.Close()
+
+	status := reader.Status()
+	assert.Equal("GeoLite2-Country", status.Edition)
+	assert.Equal(SourceDownload, status.Source)
+	assert.Equal(fmt.Sprintf("%x", md5.Sum(archive)), status.Checksum)
+	assert.Equal(filepath.Join(storeDir, "GeoLite2-Country.mmdb"), status.Path)
+	assert.Equal(uint(7), status.BuildEpoch)
+	assert.True(status.LastCheck.After(before))
+	assert.False(status.LastUpdate.IsZero())
+	assert.Equal(status.LastSuccess, status.LastUpdate)
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	reader.Pause()
+	for i := 1; i <= 2; i++ {
+		_, err := reader.Update(context.Background())
+		assert.Error(err)
+		status = reader.Status()
+		assert.True(errors.Is(err, status.LastError))
+		assert.Equal(i, status.ConsecutiveFailures)
+		assert.True(status.Paused)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	_, err = reader.Update(context.Background())
+	assert.NoError(err)
+	status = reader.Status()
+	assert.NoError(status.LastError)
+	assert.Equal(0, status.ConsecutiveFailures)
+	assert.True(status.LastSuccess.After(status.LastUpdate))
+}
+
+func TestDownloadReader_Redact(t *testing.T) {
+	assert := assert.New(t)
+
+	licenseKey := "secret-license-key"
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "http://127.0.0.1:1/"+req.URL.RawQuery, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	tests := map[string]struct {
+		format string
+	}{
+		"query":    {format: "http://127.0.0.1:1/app/geoip_download?license_key=%s&edition_id=%s&suffix=%s"},
+		"path":     {format: "http://127.0.0.1:1/%s/%s.%s"},
+		"parse":    {format: "http://127.0.0.1:1/\x7f?license_key=%s&edition_id=%s&suffix=%s"},
+		"redirect": {format: redirect.URL + "/?license_key=%s&edition_id=%s&suffix=%s"},
+	}
+
+	for name, t := range tests {
+		var errs []error
+		logger := &testLogger{}
+		downloadURL := fmt.Sprintf(t.format, licenseKey, "GeoLite2-Country", GZIP)
+		checksumURL := fmt.Sprintf(t.format, licenseKey, "GeoLite2-Country", MD5)
+		reader := newTestDownloadReader(&downloadConfig{
+			licenseKey:   licenseKey,
+			editionId:    "GeoLite2-Country",
+			downloadURLs: []string{downloadURL, downloadURL},
+			checksumURLs: []string{checksumURL, checksumURL},
+			retries:      1,
+			errorFunc:    func(err error) { errs = append(errs, err) },
+			logger:       logger,
+		})
+		_, err := reader.update(context.Background())
+		reader.cancel()
+
+		assert.Error(err, name)
+		errs = append(errs, err, reader.Status().LastError)
+		for _, err := range errs {
+			assert.NotContains(err.Error(), licenseKey, name)
+		}
+		assert.NotEmpty(logger.entries, name)
+		for _, entry := range logger.entries {
+			assert.NotContains(fmt.Sprint(entry.keysAndValues...), licenseKey, name)
+		}
+	}
+}
+
+func TestDownloadReader_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 7, "KR")})
+	broken := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": []byte("broken")})
+
+	tests := map[string]struct {
+		handler    http.Handler
+		op         Stage
+		statusCode int
+		mismatch   bool
+		reload     bool
+	}{
+		"unauthorized": {handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}), op: StageChecksum, statusCode: http.StatusUnauthorized},
+		"too-many-requests": {handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasSuffix(req.URL.Query().Get("suffix"), ".md5") {
+				fmt.Fprintf(w, "%x", md5.Sum(archive))
+				return
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+		}), op: StageDownload, statusCode: http.StatusTooManyRequests},
+		"mismatch": {handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasSuffix(req.URL.Query().Get("suffix"), ".md5") {
+				fmt.Fprintf(w, "%x", md5.Sum(broken))
+				return
+			}
+			w.Write(archive)
+		}), op: StageDownload, mismatch: true},
+		"reload": {handler: testHandler(broken), reload: true},
+	}
+
+	for name, t := range tests {
+		server := httptest.NewServer(t.handler)
+		storeDir, err := ioutil.TempDir("", "geoip2-errors")
+		assert.NoError(err)
+
+		format := testFormat(server)
+		downloadURL := fmt.Sprintf(format, "license-key", "GeoLite2-Country", GZIP)
+		checksumURL := fmt.Sprintf(format, "license-key", "GeoLite2-Country", MD5)
+		reader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+			downloadURLs: []string{downloadURL}, checksumURLs: []string{checksumURL}, retries: 1})
+		_, err = reader.update(context.Background())
+		reader.cancel()
+		reader.unlockStore()
+		assert.Error(err, name)
+
+		var derr *DownloadError
+		var merr *ChecksumMismatchError
+		var rerr *ReloadError
+		assert.Equal(t.op != "", errors.As(err, &derr), name)
+		assert.Equal(t.mismatch, errors.As(err, &merr), name)
+		assert.Equal(t.reload, errors.As(err, &rerr), name)
+		if derr != nil {
+			assert.Equal(t.op, derr.Op, name)
+			assert.Equal("GeoLite2-Country", derr.Edition, name)
+			assert.Equal(t.statusCode, derr.StatusCode, name)
+			assert.Equal(1, derr.Attempt, name)
+		}
+		if rerr != nil {
+			assert.Equal("GeoLite2-Country", rerr.Edition, name)
+			assert.Equal(storeDir, filepath.Dir(rerr.Path), name)
+		}
+
+		server.Close()
+		os.RemoveAll(storeDir)
+	}
+}
+
+func TestDownloadReader_Retry(t *testing.T) {
+	assert := assert.New(t)
+
+	archive := testArchive(map[string][]byte{"GeoLite2-Country.mmdb": testDatabase("GeoLite2-Country", 7, "KR")})
+
+	tests := map[string]struct {
+		checksumFails   int32
+		downloadFails   int32
+		checksumRetries int
+		downloadRetries int
+		stop            bool
+		checksums       int32
+		downloads       int32
+		backoffs        int
+		updated         bool
+	}{
+		"success":           {checksumRetries: 3, downloadRetries: 3, checksums: 1, downloads: 1, updated: true},
+		"checksum-retry":    {checksumFails: 1, checksumRetries: 3, downloadRetries: 3, checksums: 2, downloads: 1, backoffs: 1, updated: true},
+		"checksum-fail":     {checksumFails: 5, checksumRetries: 3, downloadRetries: 3, checksums: 3, backoffs: 2},
+		"download-retry":    {downloadFails: 2, checksumRetries: 1, downloadRetries: 3, checksums: 1, downloads: 3, backoffs: 2, updated: true},
+		"download-fail":     {downloadFails: 5, checksumRetries: 5, downloadRetries: 2, checksums: 1, downloads: 2, backoffs: 1},
+		"backoff-stop":      {checksumFails: 5, checksumRetries: 3, downloadRetries: 3, stop: true, checksums: 1, backoffs: 1},
+		"default-one-retry": {checksumFails: 5, checksums: 1},
+	}
+
+	for name, t := range tests {
+		var checksums, downloads int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasSuffix(req.URL.Query().Get("suffix"), ".md5") {
+				if atomic.AddInt32(&checksums, 1) <= t.checksumFails {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintf(w, "%x", md5.Sum(archive))
+				return
+			}
+			if atomic.AddInt32(&downloads, 1) <= t.downloadFails {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(archive)
+		}))
+		storeDir, err := ioutil.TempDir("", "geoip2-retry")
+		assert.NoError(err)
+
+		b := &testBackOff{stop: t.stop}
+		format := testFormat(server)
+		reader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", storeDir: storeDir,
+			downloadURLs:    []string{fmt.Sprintf(format, "license-key", "GeoLite2-Country", GZIP)},
+			checksumURLs:    []string{fmt.Sprintf(format, "license-key", "GeoLite2-Country", MD5)},
+			checksumRetries: t.checksumRetries, downloadRetries: t.downloadRetries, backoff: b})
+		result, err := reader.update(context.Background())
+		assert.Equal(t.updated, err == nil, name)
+		assert.Equal(t.updated, result.Updated, name)
+		assert.Equal(t.checksums, atomic.LoadInt32(&checksums), name)
+		assert.Equal(t.downloads, atomic.LoadInt32(&downloads), name)
+		assert.Equal(t.backoffs, b.calls, name)
+		reader.cancel()
+		reader.unlockStore()
+		if db := reader.activeDB(); db != nil {
+			db.Close()
+		}
+
+		server.Close()
+		os.RemoveAll(storeDir)
+	}
+}
+
+func TestDownloadReader_Clock(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, "checksum")
+	}))
+	defer server.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := newTestClock(now)
+	reader := newTestDownloadReader(&downloadConfig{editionId: "GeoLite2-Country", checksum: "checksum",
+		checksumURLs: []string{server.URL}, updateInterval: time.Hour, clock: clock})
+	go reader.runDownloadURL()
+	defer reader.Close()
+
+	for i := 1; i <= 3; i++ {
+		assert.True(clock.waitTimers(1))
+		assert.Equal(int32(i), atomic.LoadInt32(&requests))
+		status := reader.Status()
+		assert.Equal(now, status.LastCheck)
+		assert.Equal(now, status.LastSuccess)
+		assert.Equal(now.Add(time.Hour), status.NextCheck)
+
+		// no update until the next check.
+		clock.Advance(time.Hour - time.Second)
+		assert.Equal(int32(i), atomic.LoadInt32(&requests))
+		clock.Advance(time.Second)
+		now = now.Add(time.Hour)
+	}
+}
+
+func TestDownloadReader_NextCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2020, 1, 1, 10, 30, 0, 0, time.UTC)
+	never, err := Cron("0 0 30 2 *")
+	assert.NoError(err)
+
+	tests := map[string]struct {
+		cfg *downloadConfig
+		min time.Time
+		max time.Time
+	}{
+		"interval": {cfg: &downloadConfig{updateInterval: time.Hour}, min: now.Add(time.Hour), max: now.Add(time.Hour)},
+		"schedule": {cfg: &downloadConfig{updateInterval: time.Hour, schedule: MaxmindReleaseDays()},
+			min: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), max: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)},
+		"jitter": {cfg: &downloadConfig{updateInterval: time.Hour, jitter: 10 * time.Minute},
+			min: now.Add(time.Hour), max: now.Add(time.Hour + 10*time.Minute)},
+		"never": {cfg: &downloadConfig{schedule: never, jitter: time.Minute}},
+	}
+
+	for name, t := range tests {
+		reader := newTestDownloadReader(t.cfg)
+		for i := 0; i < 20; i++ {
+			next := reader.nextCheck(now)
+			assert.False(next.Before(t.min), name)
+			assert.False(next.After(t.max), name)
+		}
+		reader.cancel()
+	}
+}
+
+// testBackOff is backoff.BackOff which counts intervals.
+type testBackOff struct {
+	calls int
+	stop  bool
+}
+
+func (b *testBackOff) NextBackOff() time.Duration {
+	b.calls++
+	if b.stop {
+		return backoff.Stop
+	}
+	return 0
+}
+
+func (b *testBackOff) Reset() {}
+
+// newTestDownloadReader returns downloadReader whose updater is not started.
+func newTestDownloadReader(cfg *downloadConfig) *downloadReader {
+	if cfg.successFunc == nil {
+		cfg.successFunc = func() {}
+	}
+	if cfg.errorFunc == nil {
+		cfg.errorFunc = func(error) {}
+	}
+	if cfg.maxDatabaseSize == 0 {
+		cfg.maxDatabaseSize = DefaultMaxDatabaseSize
+	}
+	if cfg.logger == nil {
+		cfg.logger = nopLogger{}
+	}
+	if cfg.clock == nil {
+		cfg.clock = realClock{}
+	}
+	if cfg.backoff == nil {
+		cfg.backoff = backoff.NewExponentialBackOff()
+	}
+	return newDownloadReader(context.Background(), cfg)
+}
@@ -0,0 +1,174 @@
+package geoip2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxmindReleaseCron checks every 4 hours on the days maxmind releases databases(Tuesday and Friday),
+	// and on the following days to cover releases in US time zones which cross midnight in UTC.
+	MaxmindReleaseCron = "0 */4 * * 2,3,5,6"
+)
+
+var (
+	ErrInvalidCron = fmt.Errorf("[err] invalid cron expression")
+)
+
+// Schedule decides when the updater checks the latest database.
+type Schedule interface {
+	// Next returns the next time to check after t. The zero time means no more checks.
+	Next(t time.Time) time.Time
+}
+
+// Every returns a schedule which checks every d.
+func Every(d time.Duration) Schedule {
+	return intervalSchedule(d)
+}
+
+// intervalSchedule checks at a fixed interval.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// MaxmindReleaseDays returns a schedule aligned to maxmind release days. It is MaxmindReleaseCron.
+func MaxmindReleaseDays() Schedule {
+	s, _ := Cron(MaxmindReleaseCron)
+	return s
+}
+
+// cronSchedule is a schedule of a cron expression in UTC.
+// Each field is a bit set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are whether day fields start with "*", because days match if either matches otherwise.
+	domStar, dowStar bool
+}
+
+// cronField is the range of a cron field.
+type cronField struct {
+	min, max int
+}
+
+var (
+	cronFields = []cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	// cronDescriptors are predefined cron expressions.
+	cronDescriptors = map[string]string{
+		"@hourly":  "0 * * * *",
+		"@daily":   "0 0 * * *",
+		"@weekly":  "0 0 * * 0",
+		"@monthly": "0 0 1 * *",
+	}
+)
+
+// Cron returns a schedule of a standard cron expression which has 5 fields(minute, hour, day of month, month and day of week).
+// A field is *, a number, a range(a-b) or a list of them with optional steps(*/n, a-b/n).
+// @hourly, @daily, @weekly and @monthly are also supported. The expression is evaluated in UTC.
+func Cron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[expr]; ok {
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("[err] Cron %q %w", expr, ErrInvalidCron)
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("[err] Cron %q %w", expr, err)
+		}
+		sets[i] = set
+	}
+	// 7 is also Sunday.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*")}, nil
+}
+
+// parseCronField returns a bit set of values in a cron field.
+func parseCronField(field string, r cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidCron
+			}
+			step = n
+			part = part[:i]
+		}
+
+		min, max := r.min, r.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			min, err1 = strconv.Atoi(bounds[0])
+			max, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, ErrInvalidCron
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, ErrInvalidCron
+			}
+			min, max = n, n
+			// a single value with step means from the value to the end.
+			if step > 1 {
+				max = r.max
+			}
+		}
+		if min < r.min || max > r.max || min > max {
+			return 0, ErrInvalidCron
+		}
+		for v := min; v <= max; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first time after t which matches the cron expression.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// a matched time must be in 5 years, or the expression never matches(e.g. February 30).
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches returns whether the day of t matches.
+// If both day of month and day of week are restricted, either of them matches like cron.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,95 @@
+package geoip2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvery(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2020, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := map[string]struct {
+		d      time.Duration
+		output time.Time
+	}{
+		"hour":   {d: time.Hour, output: now.Add(time.Hour)},
+		"minute": {d: time.Minute, output: now.Add(time.Minute)},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, Every(t.d).Next(now))
+	}
+}
+
+func TestCron(t *testing.T) {
+	assert := assert.New(t)
+
+	// 2020-01-01 is Wednesday.
+	now := time.Date(2020, 1, 1, 10, 30, 20, 0, time.UTC)
+	tests := map[string]struct {
+		expr   string
+		now    time.Time
+		output time.Time
+		err    bool
+	}{
+		"every-minute":  {expr: "* * * * *", now: now, output: time.Date(2020, 1, 1, 10, 31, 0, 0, time.UTC)},
+		"hourly":        {expr: "@hourly", now: now, output: time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		"daily":         {expr: "@daily", now: now, output: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		"weekly":        {expr: "@weekly", now: now, output: time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC)},
+		"monthly":       {expr: "@monthly", now: now, output: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		"step":          {expr: "*/15 * * * *", now: now, output: time.Date(2020, 1, 1, 10, 45, 0, 0, time.UTC)},
+		"range-step":    {expr: "0 8-20/6 * * *", now: now, output: time.Date(2020, 1, 1, 14, 0, 0, 0, time.UTC)},
+		"value-step":    {expr: "0 20/2 * * *", now: now, output: time.Date(2020, 1, 1, 20, 0, 0, 0, time.UTC)},
+		"list":          {expr: "5,10 9,11 * * *", now: now, output: time.Date(2020, 1, 1, 11, 5, 0, 0, time.UTC)},
+		"weekday":       {expr: "0 3 * * 2,5", now: now, output: time.Date(2020, 1, 3, 3, 0, 0, 0, time.UTC)},
+		"sunday-7":      {expr: "0 0 * * 7", now: now, output: time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC)},
+		"month":         {expr: "0 0 1 3 *", now: now, output: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		"leap-day":      {expr: "0 0 29 2 *", now: now, output: time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		"day-or":        {expr: "0 0 15 * 5", now: now, output: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+		"year-end":      {expr: "0 0 1 1 *", now: now, output: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"local-time":    {expr: "0 11 * * *", now: now.In(time.FixedZone("KST", 9*3600)), output: time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		"never":         {expr: "0 0 30 2 *", now: now, output: time.Time{}},
+		"fields":        {expr: "* * * *", err: true},
+		"out-of-range":  {expr: "60 * * * *", err: true},
+		"reverse-range": {expr: "* 5-1 * * *", err: true},
+		"zero-step":     {expr: "*/0 * * * *", err: true},
+		"invalid":       {expr: "a * * * *", err: true},
+		"empty":         {expr: "", err: true},
+	}
+
+	for name, t := range tests {
+		schedule, err := Cron(t.expr)
+		if t.err {
+			assert.True(errors.Is(err, ErrInvalidCron), name)
+			continue
+		}
+		assert.NoError(err, name)
+		assert.True(t.output.Equal(schedule.Next(t.now)), name)
+	}
+}
+
+func TestMaxmindReleaseDays(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		now    time.Time
+		output time.Time
+	}{
+		// Monday.
+		"monday": {now: time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC), output: time.Date(2020, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// Tuesday.
+		"tuesday": {now: time.Date(2020, 1, 7, 10, 0, 0, 0, time.UTC), output: time.Date(2020, 1, 7, 12, 0, 0, 0, time.UTC)},
+		// Wednesday evening.
+		"wednesday": {now: time.Date(2020, 1, 8, 22, 0, 0, 0, time.UTC), output: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)},
+		// Saturday evening.
+		"saturday": {now: time.Date(2020, 1, 11, 21, 0, 0, 0, time.UTC), output: time.Date(2020, 1, 14, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for name, t := range tests {
+		assert.Equal(t.output, MaxmindReleaseDays().Next(t.now), name)
+	}
+}
@@ -0,0 +1,204 @@
+package geoip2
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	geoip2_golang "github.com/oschwald/geoip2-golang"
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	// DefaultWatchInterval is the default interval to check a watched file.
+	DefaultWatchInterval = 5 * time.Second
+)
+
+var (
+	ErrFileChanging = fmt.Errorf("[err] file is changing")
+	ErrDatabaseType = fmt.Errorf("[err] database type is changed")
+)
+
+// OpenWatch returns UpdateReader of a local database file(e.g. written by geoipupdate or mounted from a volume),
+// which is reloaded when the file is changed.
+// The file is checked every DefaultWatchInterval(WithUpdateInterval or WithSchedule to change) by its size,
+// modification time and identity. A changed file is read into memory and verified before it replaces the active database,
+// and the active database keeps serving if the file is invalid.
+// It returns an error if the file can't be loaded first, unless a seed database or WithNonBlocking is given.
+func OpenWatch(path string, opts ...DownloadOption) (UpdateReader, error) {
+	if path == "" {
+		return nil, fmt.Errorf("[err] OpenWatch %w", ErrInvalidParameters)
+	}
+
+	cfg := &downloadConfig{
+		editionId:       strings.TrimSuffix(filepath.Base(path), ".mmdb"),
+		watchPath:       path,
+		checksumSuffix:  MD5,
+		maxDatabaseSize: DefaultMaxDatabaseSize,
+		updateInterval:  DefaultWatchInterval,
+		retries:         1,
+		successFunc:     func() {},
+		errorFunc:       func(err error) {},
+		logger:          nopLogger{},
+		clock:           realClock{},
+	}
+
+	// dependency injection.
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+	if cfg.checksumSuffix != MD5 && cfg.checksumSuffix != SHA256 {
+		return nil, fmt.Errorf("[err] OpenWatch checksum suffix %w", ErrInvalidParameters)
+	}
+	if cfg.maxDatabaseSize <= 0 {
+		return nil, fmt.Errorf("[err] OpenWatch max database size %w", ErrInvalidParameters)
+	}
+
+	reader := newDownloadReader(context.Background(), cfg)
+	if _, err := reader.watchUpdate(); err != nil {
+		switch {
+		case reader.cfg.seed != nil:
+			// using seed database until the file is loaded.
+			if err := reader.seedLoad(); err != nil {
+				reader.cancel()
+				return nil, fmt.Errorf("[err] OpenWatch %w", err)
+			}
+		case !reader.cfg.nonBlocking:
+			reader.cancel()
+			return nil, fmt.Errorf("[err] OpenWatch %w", err)
+		}
+	}
+
+	// watch the file async
+	go reader.runDownloadURL()
+	return reader, nil
+}
+
+// watchUpdate reloads the watched file if it is changed.
+func (r *downloadReader) watchUpdate() (UpdateResult, error) {
+	path := r.cfg.watchPath
+	r.RLock()
+	watchInfo, failedInfo, watchErr := r.storeInfo, r.failedInfo, r.watchErr
+	checksum := r.cfg.checksum
+	r.RUnlock()
+	result := UpdateResult{Checksum: checksum}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		err = r.reloadError(path, err)
+		r.downloadFailed(StageReload, 1, err)
+		return result, err
+	}
+	if watchInfo != nil && sameFileInfo(watchInfo, info) {
+		r.emit(UpToDate{Edition: r.cfg.editionId, Checksum: checksum})
+		return result, nil
+	}
+	// an invalid file is reported only once until it is changed.
+	if failedInfo != nil && sameFileInfo(failedInfo, info) {
+		return result, watchErr
+	}
+
+	data, stage, err := r.watchRead(path, info)
+	if err != nil {
+		if stage == StageVerify {
+			r.Lock()
+			r.failedInfo, r.watchErr = info, err
+			r.Unlock()
+		}
+		r.downloadFailed(stage, 1, err)
+		if stage == StageVerify && r.loaded() {
+			r.emit(RolledBack{Edition: r.cfg.editionId, Err: err})
+		}
+		return result, err
+	}
+
+	hash := r.cfg.checksumHash()
+	hash.Write(data)
+	newChecksum := hex.EncodeToString(hash.Sum(nil))
+
+	// a touched file needs no reload.
+	if newChecksum == checksum {
+		r.Lock()
+		r.storeInfo = info
+		r.Unlock()
+		r.emit(UpToDate{Edition: r.cfg.editionId, Checksum: checksum})
+		return result, nil
+	}
+
+	db, err := geoip2_golang.FromBytes(data)
+	if err != nil {
+		err = r.reloadError(path, err)
+		r.downloadFailed(StageReload, 1, err)
+		return result, err
+	}
+
+	r.Lock()
+	old := r.activeDB()
+	if old != nil && old.Metadata().DatabaseType != db.Metadata().DatabaseType {
+		r.failedInfo = info
+		r.watchErr = r.reloadError(path, fmt.Errorf("%s to %s %w",
+			old.Metadata().DatabaseType, db.Metadata().DatabaseType, ErrDatabaseType))
+		err = r.watchErr
+		r.Unlock()
+		db.Close()
+		r.downloadFailed(StageVerify, 1, err)
+		r.emit(RolledBack{Edition: r.cfg.editionId, Err: err})
+		return result, err
+	}
+	var oldEpoch uint
+	if old != nil {
+		oldEpoch = old.Metadata().BuildEpoch
+	}
+	r.retireDB(r.swapDB(db))
+	r.source = SourceFile
+	r.storeInfo = info
+	r.failedInfo, r.watchErr = nil, nil
+	r.cfg.checksum = newChecksum
+	r.setReady()
+	r.Unlock()
+
+	r.emit(Reloaded{Edition: r.cfg.editionId, OldEpoch: oldEpoch, NewEpoch: db.Metadata().BuildEpoch, Checksum: newChecksum})
+	return UpdateResult{Updated: true, Checksum: newChecksum}, nil
+}
+
+// watchRead reads and verifies the watched file whose info is checked before.
+// It returns the stage where reading failed.
+func (r *downloadReader) watchRead(path string, info os.FileInfo) ([]byte, Stage, error) {
+	if info.Size() > r.cfg.maxDatabaseSize {
+		return nil, StageVerify, r.reloadError(path, ErrArchiveTooLarge)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, StageReload, r.reloadError(path, err)
+	}
+
+	// the file must not be changed while it is read, otherwise it is read again in the next check.
+	after, err := os.Stat(path)
+	if err != nil {
+		return nil, StageReload, r.reloadError(path, err)
+	}
+	if !sameFileInfo(info, after) || int64(len(data)) != info.Size() {
+		return nil, StageReload, r.reloadError(path, ErrFileChanging)
+	}
+
+	// validate before swap.
+	mmdb, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, StageVerify, r.reloadError(path, err)
+	}
+	defer mmdb.Close()
+	if err := mmdb.Verify(); err != nil {
+		return nil, StageVerify, r.reloadError(path, err)
+	}
+	return data, "", nil
+}
+
+// sameFileInfo returns whether a and b are infos of the same unchanged file.
+func sameFileInfo(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
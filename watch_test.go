@@ -0,0 +1,175 @@
+package geoip2
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-watch")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	assert.NoError(ioutil.WriteFile(valid, testDatabase("GeoLite2-Country", 7, "KR"), 0644))
+	invalid := filepath.Join(dir, "invalid.mmdb")
+	assert.NoError(ioutil.WriteFile(invalid, []byte("invalid"), 0644))
+
+	tests := map[string]struct {
+		path   string
+		opts   []DownloadOption
+		err    bool
+		source Source
+	}{
+		"empty":        {path: "", err: true},
+		"not-found":    {path: filepath.Join(dir, "not-found.mmdb"), err: true},
+		"invalid":      {path: invalid, err: true},
+		"non-blocking": {path: filepath.Join(dir, "not-found.mmdb"), opts: []DownloadOption{WithNonBlocking(true)}, source: SourceNone},
+		"seed":         {path: invalid, opts: []DownloadOption{WithSeedBytes(testDatabase("GeoLite2-Country", 1, "US"))}, source: SourceSeed},
+		"success":      {path: valid, source: SourceFile},
+	}
+
+	for name, t := range tests {
+		reader, err := OpenWatch(t.path, t.opts...)
+		if t.err {
+			assert.Error(err, name)
+			continue
+		}
+		assert.NoError(err, name)
+		assert.Equal(t.source, reader.Source(), name)
+		reader.Close()
+	}
+}
+
+func TestDownloadReader_Watch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "geoip2-watch")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	// files are replaced like geoipupdate.
+	write := func(data []byte) {
+		temp := path + ".temp"
+		assert.NoError(ioutil.WriteFile(temp, data, 0644))
+		assert.NoError(os.Rename(temp, path))
+	}
+	write(testDatabase("GeoLite2-Country", 7, "KR"))
+
+	var mu sync.Mutex
+	var events []Event
+	reader, err := OpenWatch(path, WithEventFunc(func(ev Event) {
+		switch e := ev.(type) {
+		case DownloadFailed:
+			e.Err = nil
+			ev = e
+		case RolledBack:
+			e.Err = nil
+			ev = e
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}))
+	assert.NoError(err)
+	defer reader.Close()
+	reader.Pause()
+
+	status := reader.Status()
+	assert.Equal("GeoLite2-Country", status.Edition)
+	assert.Equal(SourceFile, status.Source)
+	assert.Equal(path, status.Path)
+	assert.Equal(uint(7), status.BuildEpoch)
+
+	edition := "GeoLite2-Country"
+	tests := []struct {
+		name      string
+		data      []byte
+		touch     bool
+		updated   bool
+		reloadErr bool
+		// invalid is whether the file fails as an invalid database.
+		invalid  bool
+		err      error
+		failures int
+		epoch    uint
+		events   []Event
+	}{
+		{name: "up-to-date", epoch: 7,
+			events: []Event{CheckStarted{Edition: edition}, UpToDate{Edition: edition, Checksum: status.Checksum}}},
+		{name: "touch", touch: true, epoch: 7,
+			events: []Event{CheckStarted{Edition: edition}, UpToDate{Edition: edition, Checksum: status.Checksum}}},
+		{name: "invalid", data: []byte("invalid"), reloadErr: true, invalid: true, failures: 1, epoch: 7,
+			events: []Event{CheckStarted{Edition: edition}, DownloadFailed{Edition: edition, Stage: StageVerify, Attempt: 1},
+				RolledBack{Edition: edition}}},
+		{name: "invalid-again", reloadErr: true, invalid: true, failures: 2, epoch: 7,
+			events: []Event{CheckStarted{Edition: edition}}},
+		{name: "reload", data: testDatabase("GeoLite2-Country", 8, "KR"), updated: true, epoch: 8},
+		{name: "database-type", data: testDatabase("GeoLite2-City", 9, "KR"), reloadErr: true, err: ErrDatabaseType,
+			failures: 1, epoch: 8,
+			events: []Event{CheckStarted{Edition: edition}, DownloadFailed{Edition: edition, Stage: StageVerify, Attempt: 1},
+				RolledBack{Edition: edition}}},
+	}
+
+	for _, t := range tests {
+		if t.data != nil {
+			write(t.data)
+		}
+		if t.touch {
+			modTime := time.Now().Add(time.Minute)
+			assert.NoError(os.Chtimes(path, modTime, modTime))
+		}
+		mu.Lock()
+		events = nil
+		mu.Unlock()
+
+		result, err := reader.Update(context.Background())
+		assert.Equal(t.updated, result.Updated, t.name)
+		if t.reloadErr {
+			var rerr *ReloadError
+			assert.True(errors.As(err, &rerr), t.name)
+		} else {
+			assert.NoError(err, t.name)
+		}
+		if t.invalid {
+			var ierr maxminddb.InvalidDatabaseError
+			assert.True(errors.As(err, &ierr), t.name)
+		}
+		if t.err != nil {
+			assert.True(errors.Is(err, t.err), t.name)
+		}
+
+		status := reader.Status()
+		assert.Equal(t.failures, status.ConsecutiveFailures, t.name)
+		assert.Equal(t.reloadErr, status.LastError != nil, t.name)
+		assert.False(status.LastSuccess.IsZero(), t.name)
+		if t.updated {
+			assert.Equal(status.LastSuccess, status.LastUpdate, t.name)
+		}
+
+		// the active database keeps serving.
+		assert.Equal(t.epoch, reader.Metadata().BuildEpoch, t.name)
+		record, err := reader.Country(net.ParseIP("1.1.1.1"))
+		assert.NoError(err, t.name)
+		assert.Equal("KR", record.Country.IsoCode, t.name)
+
+		if t.events != nil {
+			mu.Lock()
+			assert.Equal(t.events, events, t.name)
+			mu.Unlock()
+		}
+	}
+}